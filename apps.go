@@ -0,0 +1,187 @@
+package traefik_themepark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// AppRule maps requests matching all of Host, PathPrefix and Headers to the theme of an app.
+type AppRule struct {
+	Host       string            `json:"host,omitempty"`
+	PathPrefix string            `json:"pathPrefix,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+
+	App        string   `json:"app,omitempty"`
+	Theme      string   `json:"theme,omitempty"`
+	LightTheme string   `json:"lightTheme,omitempty"`
+	DarkTheme  string   `json:"darkTheme,omitempty"`
+	Addons     []string `json:"addons,omitempty"`
+	Target     string   `json:"target,omitempty"`
+}
+
+// profile holds the resolved configuration used for requests matching rule.
+type profile struct {
+	rule   *AppRule
+	config *Config
+	// target is only set when a target overrides injecting at the end of the head or body.
+	target *regexp.Regexp
+	// detected holds the profiles of every app when the app is detected from the response.
+	detected map[string]*profile
+}
+
+type profileContextKey struct{}
+
+func newProfile(config *Config, rule *AppRule) (*profile, error) {
+	var detected map[string]*profile
+
+	if config.App == autoApp {
+		var err error
+
+		// Detected profiles must be created before defaults based on the 'auto' app are applied.
+		detected, err = newDetectedProfiles(config, rule)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	config.setDefaults()
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	result, err := compileProfile(config, rule)
+	if err != nil {
+		return nil, err
+	}
+
+	result.detected = detected
+
+	return result, nil
+}
+
+// compileProfile compile the target of a config that already has defaults applied.
+func compileProfile(config *Config, rule *AppRule) (*profile, error) {
+	result := &profile{
+		rule:   rule,
+		config: config,
+	}
+
+	if !config.defaultTarget {
+		target, err := regexp.Compile(config.Target)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling target %q: %w", config.Target, err)
+		}
+
+		result.target = target
+	}
+
+	return result, nil
+}
+
+func newRuleProfile(config *Config, rule *AppRule) (*profile, error) {
+	ruleConfig, err := config.forRule(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	return newProfile(ruleConfig, rule)
+}
+
+// forRule create a copy of config with the app specific values of rule.
+func (config *Config) forRule(rule *AppRule) (*Config, error) {
+	if rule.App == "" {
+		return nil, errors.New("app is required")
+	}
+
+	ruleConfig := config.clone()
+	ruleConfig.App = rule.App
+	ruleConfig.Theme = rule.Theme
+	ruleConfig.LightTheme = rule.LightTheme
+	ruleConfig.DarkTheme = rule.DarkTheme
+	ruleConfig.Addons = append([]string{}, rule.Addons...)
+	ruleConfig.Target = rule.Target
+	ruleConfig.defaultTarget = false
+
+	return ruleConfig, nil
+}
+
+// clone create a copy of config, without app rules, that can receive its own defaults.
+func (config *Config) clone() *Config {
+	result := *config
+	result.Apps = nil
+	result.Addons = append([]string{}, config.Addons...)
+	// Defaults depend on the app so each copy needs its own lists.
+	result.Switcher.Themes = append([]string{}, config.Switcher.Themes...)
+	result.Switcher.Addons = append([]string{}, config.Switcher.Addons...)
+
+	return &result
+}
+
+// matches check if req fulfills every condition of the rule. The path is ignored for reserved paths
+// so apps hosted below a path prefix can still reach the middleware's own endpoints.
+func (rule *AppRule) matches(req *http.Request, ignorePath bool) bool {
+	if rule.Host != "" && !matchesHost(rule.Host, getRequestHost(req)) {
+		return false
+	}
+
+	if !ignorePath && rule.PathPrefix != "" && !strings.HasPrefix(req.URL.Path, rule.PathPrefix) {
+		return false
+	}
+
+	for name, value := range rule.Headers {
+		if req.Header.Get(name) != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesHost compare host against pattern supporting a leading '*.' wildcard.
+func matchesHost(pattern, host string) bool {
+	pattern = strings.ToLower(pattern)
+
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+
+	return pattern == host
+}
+
+func getRequestHost(req *http.Request) string {
+	host := req.Host
+	if splitHost, _, err := net.SplitHostPort(host); err == nil {
+		host = splitHost
+	}
+
+	return strings.ToLower(host)
+}
+
+// getProfile find the profile for req, using the first matching rule before the fallback.
+func (themePark *themeParkHandler) getProfile(req *http.Request) *profile {
+	ignorePath := strings.HasPrefix(req.URL.Path, themePark.reservedPath+"/")
+
+	for _, ruleProfile := range themePark.profiles {
+		if ruleProfile.rule.matches(req, ignorePath) {
+			return ruleProfile
+		}
+	}
+
+	return themePark.fallback
+}
+
+func withProfile(req *http.Request, requestProfile *profile) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), profileContextKey{}, requestProfile))
+}
+
+func getRequestProfile(req *http.Request) *profile {
+	requestProfile, _ := req.Context().Value(profileContextKey{}).(*profile)
+
+	return requestProfile
+}
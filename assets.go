@@ -0,0 +1,276 @@
+package traefik_themepark
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/packruler/traefik-themepark/cssutil"
+)
+
+const (
+	defaultAssetsCacheTTL = 24 * time.Hour
+	// assetErrorTTL is how long an asset that could not be mirrored is answered with the same error.
+	assetErrorTTL = 30 * time.Second
+	// maxAssetEntries limits how many mirrored assets, including failed ones, are kept in memory.
+	maxAssetEntries = 1024
+)
+
+var errAssetNotFound = errors.New("asset not found")
+
+// fontTypes lists content types missing from Go's builtin mime table.
+var fontTypes = map[string]string{
+	".eot":   "application/vnd.ms-fontobject",
+	".otf":   "font/otf",
+	".ttf":   "font/ttf",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+}
+
+// Assets holds the configuration for serving theme.park assets from the middleware.
+type Assets struct {
+	// Directory is a local copy of theme.park laid out like the site, e.g. '<directory>/css/base/sonarr/nord.css'.
+	Directory string `json:"directory,omitempty"`
+	// Mirror fetches assets missing from Directory from the original baseUrl and keeps them in memory for CacheTTL.
+	Mirror   bool   `json:"mirror,omitempty"`
+	CacheTTL string `json:"cacheTtl,omitempty"`
+
+	// source is the original baseUrl that assets are mirrored from.
+	source string
+}
+
+func (assets *Assets) isEnabled() bool {
+	return assets.Directory != "" || assets.Mirror
+}
+
+// setDefaults point the injected links at the reserved path when assets are served locally.
+func (assets *Assets) setDefaults(config *Config) {
+	if !assets.isEnabled() || assets.source != "" {
+		return
+	}
+
+	assets.source = strings.TrimSuffix(config.BaseURL, "/")
+	config.BaseURL = config.ReservedPath
+}
+
+// assetServer serves theme.park assets from a local directory or an in-memory mirror.
+type assetServer struct {
+	prefix    string
+	directory string
+	source    string
+	mirror    bool
+	client    *http.Client
+	ttl       time.Duration
+	mutex     sync.Mutex
+	entries   map[string]*assetEntry
+}
+
+// assetEntry a mirrored asset or the error fetching it, which are set before loaded is closed.
+type assetEntry struct {
+	body    []byte
+	err     error
+	expires time.Time
+	// done tells whether loaded was closed while holding the mutex.
+	done   bool
+	loaded chan struct{}
+}
+
+func newAssetServer(config *Config) (*assetServer, error) {
+	duration := defaultAssetsCacheTTL
+
+	if config.Assets.CacheTTL != "" {
+		var err error
+
+		duration, err = time.ParseDuration(config.Assets.CacheTTL)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing assets cacheTtl %q: %w", config.Assets.CacheTTL, err)
+		}
+	}
+
+	return &assetServer{
+		prefix:    config.ReservedPath,
+		directory: config.Assets.Directory,
+		source:    config.Assets.source,
+		mirror:    config.Assets.Mirror,
+		client:    &http.Client{Timeout: fetchTimeout},
+		ttl:       duration,
+		entries:   make(map[string]*assetEntry),
+	}, nil
+}
+
+func (assets *assetServer) ServeHTTP(response http.ResponseWriter, req *http.Request) {
+	assetPath := strings.TrimPrefix(req.URL.Path, assets.prefix)
+
+	body, err := assets.load(assetPath)
+	if errors.Is(err, errAssetNotFound) {
+		http.NotFound(response, req)
+
+		return
+	}
+
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusBadGateway)
+
+		return
+	}
+
+	response.Header().Set("Content-Type", getContentType(assetPath, body))
+	response.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(response, req, assetPath, time.Time{}, bytes.NewReader(body))
+}
+
+// load get the asset at assetPath, relative to the theme.park root, from the directory or the mirror.
+func (assets *assetServer) load(assetPath string) ([]byte, error) {
+	// Cleaning a rooted path removes any '..' that could escape the directory.
+	assetPath = path.Clean("/" + assetPath)
+
+	body, err := assets.loadFile(assetPath)
+	if errors.Is(err, errAssetNotFound) && assets.mirror {
+		body, err = assets.loadMirror(assetPath)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if path.Ext(assetPath) == ".css" {
+		body = assets.rewriteReferences(body)
+	}
+
+	return body, nil
+}
+
+func (assets *assetServer) loadFile(assetPath string) ([]byte, error) {
+	if assets.directory == "" {
+		return nil, errAssetNotFound
+	}
+
+	body, err := os.ReadFile(filepath.Join(assets.directory, filepath.FromSlash(assetPath)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", errAssetNotFound, assetPath)
+	}
+
+	return body, err
+}
+
+// loadMirror get the asset at assetPath from the cache or the original baseUrl. Concurrent requests for an
+// asset share one fetch, and failures are kept for assetErrorTTL so the origin is not asked on every request.
+func (assets *assetServer) loadMirror(assetPath string) ([]byte, error) {
+	entry, fetching := assets.lookup(assetPath)
+
+	if fetching {
+		body, err := assets.fetch(assetPath)
+		ttl := assets.ttl
+
+		if err != nil {
+			ttl = assetErrorTTL
+		}
+
+		assets.mutex.Lock()
+		entry.body = body
+		entry.err = err
+		entry.expires = time.Now().Add(ttl)
+		entry.done = true
+		assets.mutex.Unlock()
+
+		close(entry.loaded)
+	}
+
+	<-entry.loaded
+
+	return entry.body, entry.err
+}
+
+// lookup get the entry of assetPath and whether the caller has to fetch it, in which case it is not loaded yet.
+func (assets *assetServer) lookup(assetPath string) (*assetEntry, bool) {
+	assets.mutex.Lock()
+	defer assets.mutex.Unlock()
+
+	now := time.Now()
+
+	entry, exists := assets.entries[assetPath]
+	if exists && (!entry.done || now.Before(entry.expires)) {
+		return entry, false
+	}
+
+	if !exists && len(assets.entries) >= maxAssetEntries {
+		assets.evict(now)
+	}
+
+	entry = &assetEntry{loaded: make(chan struct{})}
+	assets.entries[assetPath] = entry
+
+	return entry, true
+}
+
+// evict remove the expired entries, or every entry when none has expired. Callers must hold the mutex.
+func (assets *assetServer) evict(now time.Time) {
+	for assetPath, entry := range assets.entries {
+		if entry.done && !now.Before(entry.expires) {
+			delete(assets.entries, assetPath)
+		}
+	}
+
+	if len(assets.entries) >= maxAssetEntries {
+		// Starting over is good enough since fetches in progress still complete for their callers.
+		assets.entries = make(map[string]*assetEntry)
+	}
+}
+
+func (assets *assetServer) fetch(assetPath string) ([]byte, error) {
+	response, err := assets.client.Get(assets.source + assetPath)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s: %w", assetPath, err)
+	}
+
+	defer func() { _ = response.Body.Close() }()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %s", errAssetNotFound, assetPath)
+	default:
+		return nil, fmt.Errorf("error fetching %s: unexpected status %d", assetPath, response.StatusCode)
+	}
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", assetPath, err)
+	}
+
+	return body, nil
+}
+
+// rewriteReferences point absolute references to theme.park in a stylesheet at the reserved path.
+func (assets *assetServer) rewriteReferences(body []byte) []byte {
+	for _, origin := range []string{assets.source, cssutil.ThemeParkURL} {
+		if origin != "" {
+			body = bytes.ReplaceAll(body, []byte(origin+"/"), []byte(assets.prefix+"/"))
+		}
+	}
+
+	return body
+}
+
+func getContentType(assetPath string, body []byte) string {
+	extension := strings.ToLower(path.Ext(assetPath))
+
+	if contentType, exists := fontTypes[extension]; exists {
+		return contentType
+	}
+
+	if contentType := mime.TypeByExtension(extension); contentType != "" {
+		return contentType
+	}
+
+	return http.DetectContentType(body)
+}
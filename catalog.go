@@ -0,0 +1,108 @@
+package traefik_themepark
+
+// catalogApps is the list of applications supported by theme.park.
+// For reference: https://docs.theme-park.dev/themes/
+var catalogApps = []string{
+	"adguard",
+	"authelia",
+	"bazarr",
+	"bitwarden",
+	"booksonic",
+	"calibre-web",
+	"deluge",
+	"dozzle",
+	"duplicacy",
+	"duplicati",
+	"emby",
+	"filebrowser",
+	"gaps",
+	"gitea",
+	"grafana",
+	"guacamole",
+	"jackett",
+	"jellyfin",
+	"kitana",
+	"lazylibrarian",
+	"librespeed",
+	"lidarr",
+	"logarr",
+	"mylar",
+	"netdata",
+	"nextcloud",
+	"nzbget",
+	"nzbhydra2",
+	"ombi",
+	"organizr",
+	"overseerr",
+	"petio",
+	"pihole",
+	"plex",
+	"portainer",
+	"prowlarr",
+	"qbittorrent",
+	"radarr",
+	"readarr",
+	"requestrr",
+	"rutorrent",
+	"sabnzbd",
+	"sonarr",
+	"synclounge",
+	"syncthing",
+	"tautulli",
+	"the-lounge",
+	"transmission",
+	"unraid",
+	"uptime-kuma",
+	"vuetorrent",
+	"webtools",
+	"whisparr",
+	"xbackbone",
+}
+
+// catalogThemes is the list of official and community themes provided by theme.park.
+// For reference: https://docs.theme-park.dev/theme-options/ and https://docs.theme-park.dev/community-themes/
+var catalogThemes = []string{
+	"aquamarine",
+	"dark",
+	"dracula",
+	"hotline",
+	"hotpink",
+	"maroon",
+	"mind",
+	"nord",
+	"onedark",
+	"organizr",
+	"overseerr",
+	"plex",
+	"space-gray",
+	// Community themes
+	"blackberry-abyss",
+	"blackberry-amethyst",
+	"blackberry-carol",
+	"blackberry-dreamscape",
+	"blackberry-flamingo",
+	"blackberry-hearth",
+	"blackberry-martian",
+	"blackberry-pumpkin",
+	"blackberry-royal",
+	"blackberry-shadow",
+	"blackberry-solar",
+	"blackberry-vanta",
+	"dead-or-alive",
+	"ibracorp",
+	"pine-shadow",
+	"power",
+	"reality",
+	"soul",
+}
+
+// catalogAddons maps applications to the addons available for them.
+// For reference: https://docs.theme-park.dev/themes/addons/
+var catalogAddons = map[string][]string{
+	"lidarr":   {"lidarr-4k-logo", "lidarr-darker"},
+	"prowlarr": {"prowlarr-4k-logo", "prowlarr-darker"},
+	"radarr":   {"radarr-4k-logo", "radarr-darker"},
+	"readarr":  {"readarr-4k-logo", "readarr-darker"},
+	"sonarr":   {"sonarr-4k-logo", "sonarr-darker"},
+	"whisparr": {"whisparr-4k-logo", "whisparr-darker"},
+}
@@ -0,0 +1,99 @@
+// Package main a command to mirror theme.park stylesheets and their resources for offline use.
+//
+// Usage:
+//
+//	themepark-mirror -config themepark.json -source https://theme-park.dev -output ./themepark
+//
+// The config file holds a theme.park middleware configuration, or a list of them, in JSON:
+//
+//	[
+//	  {"app": "sonarr", "theme": "nord", "addons": ["4k-logo"]},
+//	  {"app": "radarr", "lightTheme": "base", "darkTheme": "nord"}
+//	]
+//
+// The output directory is laid out like theme.park and can be served as the middleware's baseUrl
+// or used as its assets directory.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	themepark "github.com/packruler/traefik-themepark"
+)
+
+func main() {
+	configPath := flag.String("config", "", "path to a JSON file with one or more middleware configurations")
+	source := flag.String("source", "https://theme-park.dev", "base URL of the theme.park instance to mirror")
+	output := flag.String("output", "themepark", "directory to write the mirrored files to")
+	flag.Parse()
+
+	if *configPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	paths, err := readStylesheetPaths(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mirror, err := newMirror(*source, *output, log.New(os.Stderr, "", log.LstdFlags))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := mirror.Run(paths); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// readStylesheetPaths load the configurations at configPath and list the stylesheets they use.
+func readStylesheetPaths(configPath string) ([]string, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config: %w", err)
+	}
+
+	configs, err := parseConfigs(data)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0)
+	for _, config := range configs {
+		paths = append(paths, config.StylesheetPaths()...)
+	}
+
+	if len(paths) == 0 {
+		return nil, errors.New("config does not contain any app")
+	}
+
+	return paths, nil
+}
+
+// parseConfigs decode either a single configuration or a list of them.
+func parseConfigs(data []byte) ([]themepark.Config, error) {
+	data = bytes.TrimSpace(data)
+
+	if bytes.HasPrefix(data, []byte("[")) {
+		var configs []themepark.Config
+		if err := json.Unmarshal(data, &configs); err != nil {
+			return nil, fmt.Errorf("error parsing config: %w", err)
+		}
+
+		return configs, nil
+	}
+
+	var config themepark.Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("error parsing config: %w", err)
+	}
+
+	return []themepark.Config{config}, nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/packruler/traefik-themepark/cssutil"
+)
+
+const fetchTimeout = 30 * time.Second
+
+var importRegex = regexp.MustCompile(`(?i)@import\s+["']([^"']+)["']`)
+
+// mirror downloads stylesheets and the resources they reference into a directory.
+type mirror struct {
+	source  *url.URL
+	origins []*url.URL
+	output  string
+	client  *http.Client
+	logger  *log.Logger
+	visited map[string]bool
+}
+
+func newMirror(source string, output string, logger *log.Logger) (*mirror, error) {
+	sourceURL, err := url.Parse(strings.TrimSuffix(source, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing source %q: %w", source, err)
+	}
+
+	// Stylesheets of self-hosted instances may still reference the public theme.park site.
+	themeParkOrigin, _ := url.Parse(cssutil.ThemeParkURL)
+
+	return &mirror{
+		source:  sourceURL,
+		origins: []*url.URL{sourceURL, themeParkOrigin},
+		output:  output,
+		client:  &http.Client{Timeout: fetchTimeout},
+		logger:  logger,
+		visited: make(map[string]bool),
+	}, nil
+}
+
+// Run download every path, relative to the source, along with the resources they reference.
+// Missing stylesheets from paths are errors while broken references are only logged.
+func (mirror *mirror) Run(paths []string) error {
+	queue := make([]string, 0)
+
+	for _, resourcePath := range paths {
+		references, err := mirror.download(resourcePath)
+		if err != nil {
+			return err
+		}
+
+		queue = append(queue, references...)
+	}
+
+	for len(queue) > 0 {
+		resourcePath := queue[0]
+		queue = queue[1:]
+
+		references, err := mirror.download(resourcePath)
+		if err != nil {
+			mirror.logger.Printf("skipping %s: %v", resourcePath, err)
+
+			continue
+		}
+
+		queue = append(queue, references...)
+	}
+
+	return nil
+}
+
+// download save resourcePath to the output directory and return the paths of the resources it references.
+func (mirror *mirror) download(resourcePath string) ([]string, error) {
+	if mirror.visited[resourcePath] {
+		return nil, nil
+	}
+
+	mirror.visited[resourcePath] = true
+
+	body, err := mirror.fetch(resourcePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var references []string
+
+	if path.Ext(resourcePath) == ".css" {
+		body, references = mirror.rewriteStylesheet(resourcePath, body)
+	}
+
+	target := filepath.Join(mirror.output, filepath.FromSlash(resourcePath))
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return nil, fmt.Errorf("error creating directory for %s: %w", resourcePath, err)
+	}
+
+	if err := os.WriteFile(target, body, 0o644); err != nil { //nolint:gosec // Mirrored files are meant to be served.
+		return nil, fmt.Errorf("error writing %s: %w", resourcePath, err)
+	}
+
+	mirror.logger.Printf("mirrored %s", resourcePath)
+
+	return references, nil
+}
+
+func (mirror *mirror) fetch(resourcePath string) ([]byte, error) {
+	resourceURL := mirror.source.String() + resourcePath
+
+	response, err := mirror.client.Get(resourceURL)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s: %w", resourceURL, err)
+	}
+
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching %s: unexpected status %d", resourceURL, response.StatusCode)
+	}
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", resourceURL, err)
+	}
+
+	return body, nil
+}
+
+// rewriteStylesheet make references to mirrored resources relative to the stylesheet and return their paths.
+// References to other sites are left untouched.
+func (mirror *mirror) rewriteStylesheet(resourcePath string, body []byte) ([]byte, []string) {
+	base, _ := url.Parse(mirror.source.String() + resourcePath)
+	references := make([]string, 0)
+
+	rewrite := func(reference string) (string, bool) {
+		if strings.HasPrefix(reference, "data:") || strings.HasPrefix(reference, "#") {
+			return "", false
+		}
+
+		resolved, err := base.Parse(strings.TrimSpace(reference))
+		if err != nil {
+			return "", false
+		}
+
+		localPath, ok := mirror.getLocalPath(resolved)
+		if !ok {
+			return "", false
+		}
+
+		references = append(references, localPath)
+
+		relative := getRelativePath(resourcePath, localPath)
+		if resolved.RawQuery != "" {
+			relative += "?" + resolved.RawQuery
+		}
+
+		if resolved.Fragment != "" {
+			relative += "#" + resolved.Fragment
+		}
+
+		return relative, true
+	}
+
+	body = cssutil.URLRegex.ReplaceAllFunc(body, func(match []byte) []byte {
+		if relative, ok := rewrite(string(cssutil.URLRegex.FindSubmatch(match)[1])); ok {
+			return []byte(fmt.Sprintf("url(%q)", relative))
+		}
+
+		return match
+	})
+
+	body = importRegex.ReplaceAllFunc(body, func(match []byte) []byte {
+		if relative, ok := rewrite(string(importRegex.FindSubmatch(match)[1])); ok {
+			return []byte(fmt.Sprintf("@import %q", relative))
+		}
+
+		return match
+	})
+
+	return body, references
+}
+
+// getLocalPath get the path of resource relative to the source if it belongs to a mirrored origin.
+func (mirror *mirror) getLocalPath(resource *url.URL) (string, bool) {
+	for _, origin := range mirror.origins {
+		if resource.Scheme != origin.Scheme || resource.Host != origin.Host {
+			continue
+		}
+
+		if strings.HasPrefix(resource.Path, origin.Path+"/") {
+			// Cleaning a rooted path removes any '..' that could escape the output directory.
+			return path.Clean(strings.TrimPrefix(resource.Path, origin.Path)), true
+		}
+	}
+
+	return "", false
+}
+
+// getRelativePath get the path of target relative to the directory of from.
+func getRelativePath(from string, target string) string {
+	relative, err := filepath.Rel(filepath.FromSlash(path.Dir(from)), filepath.FromSlash(target))
+	if err != nil {
+		return target
+	}
+
+	return filepath.ToSlash(relative)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMirror(t *testing.T) {
+	files := map[string]string{
+		"/css/base/sonarr/nord.css": "@import url(\"https://theme-park.dev/css/theme-options/nord.css\");\n" +
+			"@import \"../sonarr-base.css\";\n" +
+			"@import url(https://fonts.example.com/font.css);",
+		"/css/base/sonarr-base.css":                            ".logo{background:url('/images/logo.png?v=2')}",
+		"/css/theme-options/nord.css":                          "@font-face{src:url(../../fonts/nord.woff2#icons)}",
+		"/fonts/nord.woff2":                                    "font",
+		"/images/logo.png":                                     "png",
+		"/css/addons/sonarr/sonarr-4k-logo/sonarr-4k-logo.css": ".logo{background:url(data:image/png;base64,AA==)}",
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, req *http.Request) {
+		content, exists := files[req.URL.Path]
+		if !exists {
+			http.NotFound(responseWriter, req)
+
+			return
+		}
+
+		_, _ = fmt.Fprint(responseWriter, content)
+	}))
+	defer server.Close()
+
+	output := t.TempDir()
+
+	mirror, err := newMirror(server.URL, output, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{"/css/base/sonarr/nord.css", "/css/addons/sonarr/sonarr-4k-logo/sonarr-4k-logo.css"}
+	if err := mirror.Run(paths); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"/css/base/sonarr/nord.css": "@import url(\"../../theme-options/nord.css\");\n" +
+			"@import \"../sonarr-base.css\";\n" +
+			"@import url(https://fonts.example.com/font.css);",
+		"/css/base/sonarr-base.css":                            ".logo{background:url(\"../../images/logo.png?v=2\")}",
+		"/css/theme-options/nord.css":                          "@font-face{src:url(\"../../fonts/nord.woff2#icons\")}",
+		"/fonts/nord.woff2":                                    "font",
+		"/images/logo.png":                                     "png",
+		"/css/addons/sonarr/sonarr-4k-logo/sonarr-4k-logo.css": ".logo{background:url(data:image/png;base64,AA==)}",
+	}
+
+	for resourcePath, content := range expected {
+		t.Run(resourcePath, func(t *testing.T) {
+			result, err := os.ReadFile(filepath.Join(output, filepath.FromSlash(resourcePath)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(result) != content {
+				t.Errorf("result: '%s' | expected: '%s'", result, content)
+			}
+		})
+	}
+}
+
+func TestMirrorMissingStylesheet(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	mirror, err := newMirror(server.URL, t.TempDir(), log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mirror.Run([]string{"/css/base/sonarr/nord.css"}); err == nil {
+		t.Error("missing stylesheet should return an error")
+	}
+}
+
+func TestParseConfigs(t *testing.T) {
+	tests := []struct {
+		desc     string
+		data     string
+		expected []string
+	}{
+		{
+			desc:     "single config",
+			data:     `{"app": "sonarr", "theme": "nord", "addons": ["4k-logo"]}`,
+			expected: []string{"/css/addons/sonarr/sonarr-4k-logo/sonarr-4k-logo.css", "/css/base/sonarr/nord.css"},
+		},
+		{
+			desc: "list of configs with light and dark themes",
+			data: `[{"app": "sonarr"}, {"app": "radarr", "lightTheme": "base", "darkTheme": "nord"}]`,
+			expected: []string{
+				"/css/base/sonarr/sonarr-base.css",
+				"/css/base/radarr/nord.css",
+				"/css/base/radarr/radarr-base.css",
+			},
+		},
+		{
+			desc: "app rules",
+			data: `{"apps": [{"app": "lidarr", "theme": "dark"}]}`,
+			expected: []string{
+				"/css/base/lidarr/dark.css",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			configs, err := parseConfigs([]byte(test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result := make([]string, 0)
+			for _, config := range configs {
+				result = append(result, config.StylesheetPaths()...)
+			}
+
+			if fmt.Sprint(result) != fmt.Sprint(test.expected) {
+				t.Errorf("result: %v | expected: %v", result, test.expected)
+			}
+		})
+	}
+}
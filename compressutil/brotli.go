@@ -0,0 +1,12 @@
+//go:build gc
+// +build gc
+
+package compressutil
+
+import "github.com/andybalholm/brotli"
+
+// Every brotli level is supported when compiled, see brotli_other.go.
+const (
+	defaultBrotliLevel = brotli.DefaultCompression
+	maxBrotliLevel     = brotli.BestCompression
+)
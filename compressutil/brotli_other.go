@@ -0,0 +1,11 @@
+//go:build !gc
+// +build !gc
+
+package compressutil
+
+// The interpreter Traefik runs plugins with can not run the brotli encoders above level 4, so the default and
+// higher levels are capped to it.
+const (
+	defaultBrotliLevel = 4
+	maxBrotliLevel     = 4
+)
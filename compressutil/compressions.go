@@ -0,0 +1,21 @@
+package compressutil
+
+const (
+	// Gzip compression algorithm string.
+	Gzip string = "gzip"
+	// Deflate compression algorithm string.
+	Deflate string = "deflate"
+	// Brotli compression algorithm string.
+	Brotli string = "br"
+	// RawDeflate format of raw DEFLATE data sent as 'deflate' by servers that do not use the zlib format.
+	// It is never used as a header value.
+	RawDeflate string = "deflate-raw"
+	// Identity compression algorithm string.
+	Identity string = "identity"
+
+	// DefaultLevel compression level using the default level of each algorithm. Other levels follow the scale
+	// of the algorithm: 1 to 9 for gzip and deflate and 1 to 11 for brotli.
+	DefaultLevel = 0
+	// MaxLevel the highest compression level of any algorithm, the highest brotli level.
+	MaxLevel = 11
+)
@@ -0,0 +1,205 @@
+// Package compressutil a plugin to handle compression and decompression tasks
+package compressutil
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"log"
+)
+
+// ReaderError for notating that an error occurred while reading compressed data.
+type ReaderError struct {
+	error
+
+	cause error
+}
+
+// Decode data in a bytes.Reader based on supplied encoding.
+func Decode(byteReader *bytes.Buffer, encoding string) ([]byte, error) {
+	reader, err := NewReader(byteReader, GetFormat(byteReader.Bytes(), encoding))
+	if err != nil {
+		return nil, &ReaderError{
+			error: err,
+			cause: err,
+		}
+	}
+
+	defer CloseReader(reader)
+
+	buffer := AcquireBuffer()
+	defer ReleaseBuffer(buffer)
+
+	if _, err := buffer.ReadFrom(reader); err != nil {
+		return nil, err
+	}
+
+	return append([]byte(nil), buffer.Bytes()...), nil
+}
+
+// GetFormat get the format of data sent with the supplied encoding. The 'deflate' encoding is meant to be zlib
+// wrapped but some servers send raw DEFLATE data, so RawDeflate is returned when data has no zlib header.
+func GetFormat(data []byte, encoding string) string {
+	if encoding == Deflate && len(data) > 0 && !isZlib(data) {
+		return RawDeflate
+	}
+
+	return encoding
+}
+
+// isZlib check if data starts with a zlib header as described in RFC 1950.
+func isZlib(data []byte) bool {
+	const (
+		deflateMethod = 8
+		maxWindowBits = 7
+		checkDivisor  = 31
+		dictionaryBit = 0x20
+	)
+
+	if len(data) < 2 {
+		return false
+	}
+
+	header := uint16(data[0])<<8 | uint16(data[1])
+
+	return data[0]&0x0f == deflateMethod &&
+		data[0]>>4 <= maxWindowBits &&
+		data[1]&dictionaryBit == 0 &&
+		header%checkDivisor == 0
+}
+
+// IsSupported check if data sent with encoding can be decoded and encoded. Identity and an empty encoding
+// are supported as well.
+func IsSupported(encoding string) bool {
+	switch encoding {
+	case Gzip, Deflate, Brotli, Identity, "":
+		return true
+	default:
+		return false
+	}
+}
+
+// Encode data in a []byte based on supplied encoding and compression level.
+func Encode(data []byte, encoding string, level int) ([]byte, error) {
+	switch encoding {
+	case Gzip, Deflate, RawDeflate, Brotli:
+		return compress(data, encoding, level)
+
+	default:
+		return data, nil
+	}
+}
+
+func compress(bodyBytes []byte, encoding string, level int) ([]byte, error) {
+	buf := AcquireBuffer()
+	defer ReleaseBuffer(buf)
+
+	writer, err := NewWriter(buf, encoding, level)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := writer.Write(bodyBytes); err != nil {
+		log.Printf("unable to recompress rewrited body: %v", err)
+
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		log.Printf("unable to close %s writer: %v", encoding, err)
+
+		return nil, err
+	}
+
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+// GetMaxLevel get the highest level data is compressed with for encoding. Higher levels are capped to it.
+func GetMaxLevel(encoding string) int {
+	if encoding == Brotli {
+		return maxBrotliLevel
+	}
+
+	return flate.BestCompression
+}
+
+// getFlateLevel get the gzip, zlib or flate level for level.
+func getFlateLevel(level int) int {
+	if level == DefaultLevel {
+		return flate.DefaultCompression
+	}
+
+	if level > flate.BestCompression {
+		return flate.BestCompression
+	}
+
+	return level
+}
+
+// getBrotliLevel get the brotli quality for level.
+func getBrotliLevel(level int) int {
+	if level == DefaultLevel {
+		return defaultBrotliLevel
+	}
+
+	if level > maxBrotliLevel {
+		return maxBrotliLevel
+	}
+
+	return level
+}
+
+// CloseReader release the resources held by a reader created by NewReader. The reader must not be used afterwards.
+func CloseReader(reader io.Reader) {
+	// Yaegi does not return pooled decoders reliably with a checked type assertion, unlike with a type switch.
+	switch decoder := reader.(type) { //nolint:gocritic // See above.
+	case *pooledReader:
+		decoder.pool.Put(decoder.reader)
+	}
+}
+
+// Writer a compressing writer that can flush the data written so far.
+type Writer interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// NewReader create a reader decoding the data of reader based on supplied encoding or format.
+// Decoders are reused between readers so it should be released with CloseReader.
+func NewReader(reader io.Reader, encoding string) (io.Reader, error) {
+	switch encoding {
+	case Gzip, Deflate, RawDeflate, Brotli:
+		return newPooledReader(reader, encoding)
+
+	default:
+		return reader, nil
+	}
+}
+
+// NewWriter create a Writer encoding data written to writer based on supplied encoding or format and level.
+// Levels above the highest level of the algorithm use the highest level.
+// Encoders are reused between writers once they are closed.
+func NewWriter(writer io.Writer, encoding string, level int) (Writer, error) {
+	switch encoding {
+	case Gzip, Deflate, RawDeflate, Brotli:
+		return newPooledWriter(writer, encoding, level)
+
+	default:
+		return &identityWriter{Writer: writer}, nil
+	}
+}
+
+// identityWriter a Writer that writes data unchanged.
+type identityWriter struct {
+	io.Writer
+}
+
+// Flush does nothing since data is written immediately.
+func (writer *identityWriter) Flush() error {
+	return nil
+}
+
+// Close does nothing since the underlying writer is owned by the caller.
+func (writer *identityWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,213 @@
+package compressutil
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// maxPooledBufferSize keeps the buffers of unusually large bodies from being held by the pool.
+const maxPooledBufferSize = 8 * 1024 * 1024
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// AcquireBuffer get an empty buffer from the pool. It should be returned with ReleaseBuffer once unused.
+func AcquireBuffer() *bytes.Buffer {
+	buffer, _ := bufferPool.Get().(*bytes.Buffer)
+
+	return buffer
+}
+
+// ReleaseBuffer return a buffer from AcquireBuffer to the pool. The buffer must not be used afterwards.
+func ReleaseBuffer(buffer *bytes.Buffer) {
+	if buffer == nil || buffer.Cap() > maxPooledBufferSize {
+		return
+	}
+
+	buffer.Reset()
+	bufferPool.Put(buffer)
+}
+
+// resettableWriter a compressing Writer that can be reused for another destination.
+type resettableWriter interface {
+	Writer
+	Reset(writer io.Writer)
+}
+
+// writerKey identifies writers that can replace each other.
+type writerKey struct {
+	encoding string
+	level    int
+}
+
+var (
+	writerPoolsLock sync.Mutex
+	writerPools     = make(map[writerKey]*sync.Pool)
+)
+
+// getWriterPool get the pool of writers for encoding at level.
+func getWriterPool(encoding string, level int) *sync.Pool {
+	writerPoolsLock.Lock()
+	defer writerPoolsLock.Unlock()
+
+	key := writerKey{encoding: encoding, level: level}
+
+	pool, ok := writerPools[key]
+	if !ok {
+		pool = &sync.Pool{}
+		writerPools[key] = pool
+	}
+
+	return pool
+}
+
+// pooledWriter returns its writer to the pool once closed. The writer is not embedded, nor compared to nil,
+// since Yaegi can not do either with interfaces holding compiled writers.
+type pooledWriter struct {
+	writer resettableWriter
+	pool   *sync.Pool
+	closed bool
+}
+
+// Write encode data to the destination of the writer.
+func (writer *pooledWriter) Write(data []byte) (int, error) {
+	return writer.writer.Write(data)
+}
+
+// Flush send the data encoded so far to the destination of the writer.
+func (writer *pooledWriter) Flush() error {
+	return writer.writer.Flush()
+}
+
+// Close finish the encoded data and return the writer to the pool.
+func (writer *pooledWriter) Close() error {
+	if writer.closed {
+		return nil
+	}
+
+	err := writer.writer.Close()
+
+	writer.pool.Put(writer.writer)
+	writer.writer = nil
+	writer.closed = true
+
+	return err
+}
+
+// newPooledWriter get a writer for encoding at level from the pool, or create one, encoding to writer.
+func newPooledWriter(writer io.Writer, encoding string, level int) (Writer, error) {
+	pool := getWriterPool(encoding, level)
+
+	if pooled, ok := pool.Get().(resettableWriter); ok {
+		pooled.Reset(writer)
+
+		return &pooledWriter{writer: pooled, pool: pool}, nil
+	}
+
+	created, err := createWriter(writer, encoding, level)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pooledWriter{writer: created, pool: pool}, nil
+}
+
+// createWriter create a new writer for encoding at level.
+func createWriter(writer io.Writer, encoding string, level int) (resettableWriter, error) {
+	switch encoding {
+	case Gzip:
+		return gzip.NewWriterLevel(writer, getFlateLevel(level))
+
+	case Deflate:
+		return zlib.NewWriterLevel(writer, getFlateLevel(level))
+
+	case RawDeflate:
+		return flate.NewWriter(writer, getFlateLevel(level))
+
+	default:
+		return brotli.NewWriterLevel(writer, getBrotliLevel(level)), nil
+	}
+}
+
+// readerPools pools of the decoders that can be reset.
+var readerPools = map[string]*sync.Pool{
+	Gzip:       {},
+	Deflate:    {},
+	RawDeflate: {},
+	Brotli:     {},
+}
+
+// pooledReader a decoder that is returned to its pool by CloseReader.
+type pooledReader struct {
+	reader io.Reader
+	pool   *sync.Pool
+}
+
+// Read decoded data from the decoder.
+func (reader *pooledReader) Read(data []byte) (int, error) {
+	return reader.reader.Read(data)
+}
+
+// newPooledReader get a decoder for encoding from the pool, or create one, decoding reader.
+func newPooledReader(reader io.Reader, encoding string) (io.Reader, error) {
+	pool := readerPools[encoding]
+
+	if pooled := pool.Get(); pooled != nil {
+		if err := resetReader(pooled, reader); err != nil {
+			return nil, err
+		}
+
+		return &pooledReader{reader: pooled.(io.Reader), pool: pool}, nil
+	}
+
+	created, err := createReader(reader, encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pooledReader{reader: created, pool: pool}, nil
+}
+
+// createReader create a new decoder for encoding.
+func createReader(reader io.Reader, encoding string) (io.Reader, error) {
+	switch encoding {
+	case Gzip:
+		return gzip.NewReader(reader)
+
+	case Deflate:
+		return zlib.NewReader(reader)
+
+	case RawDeflate:
+		return flate.NewReader(reader), nil
+
+	default:
+		return brotli.NewReader(reader), nil
+	}
+}
+
+// resetReader make a pooled decoder decode reader.
+func resetReader(pooled interface{}, reader io.Reader) error {
+	switch decoder := pooled.(type) {
+	case *gzip.Reader:
+		return decoder.Reset(reader)
+
+	case *brotli.Reader:
+		return decoder.Reset(reader)
+
+	case flate.Resetter:
+		// Also matches zlib decoders, which implement zlib.Resetter with the same signature.
+		return decoder.Reset(reader, nil)
+
+	default:
+		return nil
+	}
+}
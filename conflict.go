@@ -0,0 +1,312 @@
+package traefik_themepark
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/packruler/traefik-themepark/cssutil"
+)
+
+const (
+	// conflictAppend injects the configured stylesheets after any existing theme.park references.
+	conflictAppend = "append"
+	// conflictReplace removes existing theme.park references before injecting the configured stylesheets.
+	conflictReplace = "replace"
+	// conflictSkip leaves pages that already reference theme.park untouched.
+	conflictSkip = "skip"
+)
+
+var conflictModes = []string{conflictAppend, conflictReplace, conflictSkip}
+
+// existingImportRegex matches '@import' rules of stylesheets with the URL as its first group.
+var existingImportRegex = regexp.MustCompile(`(?i)@import\s+(?:url\(\s*)?["']?([^"')\s;]+)[^;]*;`)
+
+// themeParkPaths the paths below a theme.park root that hold its stylesheets.
+var themeParkPaths = []string{"/css/base/", "/css/addons/"}
+
+// referenceSpan the position of markup referencing theme.park in a page.
+type referenceSpan struct {
+	start int
+	end   int
+}
+
+func validateConflictMode(mode string) error {
+	if !containsString(conflictModes, mode) {
+		return fmt.Errorf("unknown conflictMode %q%s", mode, suggestionHint(mode, conflictModes))
+	}
+
+	return nil
+}
+
+// getReferenceRoots get the URLs theme.park stylesheets of config may be linked from: theme.park itself,
+// the configured baseUrl and the reserved path local assets are served below.
+func getReferenceRoots(config *Config, assets *assetServer) []string {
+	roots := []string{cssutil.ThemeParkURL, config.BaseURL, config.ReservedPath}
+
+	if assets != nil {
+		roots = append(roots, assets.source)
+	}
+
+	return roots
+}
+
+// hasThemeParkReference check if body already includes theme.park stylesheets linked from roots.
+func hasThemeParkReference(body []byte, roots []string) bool {
+	return len(findThemeParkReferences(body, roots)) > 0
+}
+
+// removeThemeParkReferences remove every theme.park stylesheet linked from roots already included in body.
+func removeThemeParkReferences(body []byte, roots []string) []byte {
+	references := findThemeParkReferences(body, roots)
+	if len(references) == 0 {
+		return body
+	}
+
+	result := make([]byte, 0, len(body))
+	index := 0
+
+	for _, reference := range references {
+		result = append(result, body[index:reference.start]...)
+		index = reference.end
+	}
+
+	return append(result, body[index:]...)
+}
+
+// findThemeParkReferences get the markup of body referencing theme.park in order. Only real tags are
+// considered so text in scripts, comments and attribute values is never mistaken for a reference.
+func findThemeParkReferences(body []byte, roots []string) []referenceSpan {
+	var references []referenceSpan
+
+	lower := toLowerASCII(body)
+
+	scanTags(body, func(tag htmlTag) {
+		switch {
+		case tag.closing:
+			return
+		case tag.name == "link" && isReferenceTag(body, tag, roots):
+			references = append(references, referenceSpan{start: tag.start, end: tag.end})
+		case isReferenceTag(body, tag, roots):
+			references = append(references, referenceSpan{start: tag.start, end: findElementEnd(lower, tag)})
+		case tag.name == "style":
+			references = append(references, findImports(body, tag.end, skipRawText(lower, tag.end, tag.name), roots)...)
+		}
+	})
+
+	return references
+}
+
+// isReferenceTag check if the start tag of body references theme.park by itself: a link to a theme.park
+// stylesheet, or a stylesheet or picker injected by this plugin.
+func isReferenceTag(body []byte, tag htmlTag, roots []string) bool {
+	markup := body[tag.start:tag.end]
+
+	switch tag.name {
+	case "link":
+		href, _ := getAttribute(markup, "href")
+
+		return isThemeParkURL(href, roots)
+	case "script":
+		_, ok := getAttribute(markup, "data-themepark-picker")
+
+		return ok
+	case "style":
+		_, ok := getAttribute(markup, "data-themepark")
+
+		return ok
+	default:
+		return false
+	}
+}
+
+// referenceInspector finds theme.park references in a page received in segments. Markup cut off at the end
+// of a segment and the style element it ends in are kept so references split between segments are found.
+type referenceInspector struct {
+	roots   []string
+	scanner htmlScanner
+	pending []byte
+	// styleStart is the position of the content of the style element pending ends in, or -1.
+	styleStart int
+	referenced bool
+}
+
+func newReferenceInspector(roots []string) *referenceInspector {
+	return &referenceInspector{roots: roots, styleStart: -1}
+}
+
+// inspect look for references in segment, the part of the page following the previous segments.
+func (inspector *referenceInspector) inspect(segment []byte) {
+	if inspector.referenced {
+		return
+	}
+
+	inspector.pending = append(inspector.pending, segment...)
+	body := inspector.pending
+
+	scanned := inspector.scanner.scan(body, func(tag htmlTag) bool {
+		switch {
+		case tag.name == "style" && tag.closing && inspector.styleStart >= 0:
+			inspector.referenced = len(findImports(body, inspector.styleStart, tag.start, inspector.roots)) > 0
+			inspector.styleStart = -1
+		case !tag.closing && isReferenceTag(body, tag, inspector.roots):
+			inspector.referenced = true
+		case !tag.closing && tag.name == "style":
+			inspector.styleStart = tag.end
+		}
+
+		return !inspector.referenced
+	})
+
+	keep := scanned
+
+	if inspector.styleStart >= 0 {
+		// Rules of the style element that are already complete do not have to wait for the element to end.
+		inspector.referenced = inspector.referenced ||
+			len(findImports(body, inspector.styleStart, len(body), inspector.roots)) > 0
+
+		if len(body)-inspector.styleStart > streamWindowSize {
+			inspector.styleStart = -1
+		} else {
+			keep = inspector.styleStart
+			inspector.styleStart = 0
+		}
+	}
+
+	// Give up on markup that is never terminated rather than keeping the whole page.
+	if inspector.referenced || len(body)-keep > streamWindowSize {
+		keep = len(body)
+	}
+
+	inspector.pending = append(inspector.pending[:0], body[keep:]...)
+}
+
+// findImports get the '@import' rules of theme.park stylesheets in the style sheet between start and end.
+func findImports(body []byte, start int, end int, roots []string) []referenceSpan {
+	var references []referenceSpan
+
+	for _, match := range existingImportRegex.FindAllSubmatchIndex(body[start:end], -1) {
+		if isThemeParkURL(string(body[start+match[2]:start+match[3]]), roots) {
+			references = append(references, referenceSpan{start: start + match[0], end: start + match[1]})
+		}
+	}
+
+	return references
+}
+
+// findElementEnd get the position after the end tag of the raw text element started by tag.
+func findElementEnd(lower []byte, tag htmlTag) int {
+	index := skipRawText(lower, tag.end, tag.name)
+
+	end := bytes.IndexByte(lower[index:], '>')
+	if end < 0 {
+		return len(lower)
+	}
+
+	return index + end + 1
+}
+
+// isThemeParkURL check if url is a theme.park stylesheet below one of roots, regardless of its scheme.
+func isThemeParkURL(url string, roots []string) bool {
+	url = trimScheme(strings.ToLower(url))
+
+	for _, root := range roots {
+		root = trimScheme(strings.ToLower(strings.TrimSuffix(root, "/")))
+		if root == "" {
+			continue
+		}
+
+		for _, path := range themeParkPaths {
+			if strings.HasPrefix(url, root+path) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func trimScheme(url string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(url, "http:"), "https:")
+}
+
+// getAttribute get the value of the attribute name of the start tag markup and whether it is present.
+func getAttribute(markup []byte, name string) (string, bool) {
+	index := 1
+	for index < len(markup) && isTagNameChar(markup[index]) {
+		index++
+	}
+
+	for index < len(markup) && markup[index] != '>' {
+		attributeName, value, next := readAttribute(markup, index)
+		if strings.EqualFold(attributeName, name) {
+			return value, true
+		}
+
+		index = next
+	}
+
+	return "", false
+}
+
+// readAttribute read the attribute starting at index, or the separators before it, and get its name and value
+// with the position after it.
+func readAttribute(markup []byte, index int) (string, string, int) {
+	index = skipAttributeSeparators(markup, index)
+	start := index
+
+	for index < len(markup) && isTagNameChar(markup[index]) && markup[index] != '=' {
+		index++
+	}
+
+	name := string(markup[start:index])
+
+	next := skipAttributeSeparators(markup, index)
+	if next >= len(markup) || markup[next] != '=' || name == "" {
+		return name, "", maxInt(index, start+1)
+	}
+
+	index = next + 1
+	for index < len(markup) && isSpace(markup[index]) {
+		index++
+	}
+
+	if index >= len(markup) {
+		return name, "", index
+	}
+
+	if quote := markup[index]; quote == '"' || quote == '\'' {
+		end := bytes.IndexByte(markup[index+1:], quote)
+		if end < 0 {
+			return name, string(markup[index+1:]), len(markup)
+		}
+
+		return name, string(markup[index+1 : index+1+end]), index + end + 2
+	}
+
+	// Unquoted values may contain '/' and end at whitespace or the end of the tag.
+	end := bytes.IndexAny(markup[index:], " \t\n\r\f>")
+	if end < 0 {
+		return name, string(markup[index:]), len(markup)
+	}
+
+	return name, string(markup[index : index+end]), index + end
+}
+
+func skipAttributeSeparators(markup []byte, index int) int {
+	for index < len(markup) && (isSpace(markup[index]) || markup[index] == '/') {
+		index++
+	}
+
+	return index
+}
+
+func isSpace(char byte) bool {
+	switch char {
+	case ' ', '\t', '\n', '\r', '\f':
+		return true
+	default:
+		return false
+	}
+}
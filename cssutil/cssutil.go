@@ -0,0 +1,10 @@
+// Package cssutil a package for finding theme.park references in stylesheets.
+package cssutil
+
+import "regexp"
+
+// ThemeParkURL the public theme.park site, which self-hosted stylesheets may still reference.
+const ThemeParkURL = "https://theme-park.dev"
+
+// URLRegex matches the url() references of a stylesheet. The first group is the referenced URL.
+var URLRegex = regexp.MustCompile(`(?i)url\(\s*["']?([^"')]+)["']?\s*\)`)
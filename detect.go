@@ -0,0 +1,188 @@
+package traefik_themepark
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// autoApp is the App value enabling detection of the app from the response body.
+const autoApp = "auto"
+
+// appMarkers maps apps to snippets of their HTML, like bootstrap scripts and asset paths,
+// that identify them more reliably than the page title.
+var appMarkers = map[string][]string{
+	"grafana":   {"window.grafanaBootData"},
+	"lidarr":    {"window.Lidarr"},
+	"nextcloud": {"data-requesttoken", "/core/js/"},
+	"prowlarr":  {"window.Prowlarr"},
+	"radarr":    {"window.Radarr"},
+	"readarr":   {"window.Readarr"},
+	"sonarr":    {"window.Sonarr"},
+	"unraid":    {"/webGui/"},
+	"whisparr":  {"window.Whisparr"},
+}
+
+var (
+	titleRegex    = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	metaRegex     = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	metaNameRegex = regexp.MustCompile(
+		`(?i)(?:name|property)\s*=\s*["']?(application-name|apple-mobile-web-app-title|og:site_name|og:title)["'\s>]`,
+	)
+	metaContentRegex = regexp.MustCompile(`(?is)content\s*=\s*["']([^"']*)["']`)
+	nonAlphanumeric  = regexp.MustCompile(`[^a-z0-9]+`)
+	alphanumericRun  = regexp.MustCompile(`[a-z0-9]+`)
+)
+
+// detectionOrder lists the catalog apps from the most to the least specific name
+// so apps are not shadowed by shorter names contained in theirs.
+var detectionOrder = getDetectionOrder()
+
+func getDetectionOrder() []string {
+	result := append([]string{}, catalogApps...)
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return len(result[i]) > len(result[j])
+	})
+
+	return result
+}
+
+// detectApp fingerprint the HTML body to find which theme.park app served it.
+// An empty string is returned if the app could not be determined.
+func detectApp(body []byte) string {
+	for _, app := range detectionOrder {
+		for _, marker := range appMarkers[app] {
+			if bytes.Contains(body, []byte(marker)) {
+				return app
+			}
+		}
+	}
+
+	names := getPageNames(body)
+
+	for _, app := range detectionOrder {
+		normalizedApp := normalizeName(app)
+
+		for _, words := range names {
+			if containsName(words, normalizedApp) {
+				return app
+			}
+		}
+	}
+
+	return ""
+}
+
+// getPageNames get the words of the title and application names from meta tags of the HTML body.
+func getPageNames(body []byte) [][]string {
+	names := make([][]string, 0)
+
+	if match := titleRegex.FindSubmatch(body); match != nil {
+		names = append(names, splitWords(string(match[1])))
+	}
+
+	for _, meta := range metaRegex.FindAll(body, -1) {
+		if !metaNameRegex.Match(meta) {
+			continue
+		}
+
+		if match := metaContentRegex.FindSubmatch(meta); match != nil {
+			names = append(names, splitWords(string(match[1])))
+		}
+	}
+
+	return names
+}
+
+// containsName check if consecutive words spell the normalized name, so 'Pi-hole' matches 'pihole'
+// while names merely contained in a word like 'plex' in 'Complex' are not matched.
+func containsName(words []string, name string) bool {
+	for start := range words {
+		joined := ""
+
+		for _, word := range words[start:] {
+			joined += word
+			if len(joined) >= len(name) {
+				break
+			}
+		}
+
+		if joined == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitWords get the lower case runs of letters and digits of value.
+func splitWords(value string) []string {
+	return alphanumericRun.FindAllString(strings.ToLower(value), -1)
+}
+
+// normalizeName lower case value and remove everything besides letters and digits
+// so 'Pi-hole' matches 'pihole' and 'Uptime Kuma' matches 'uptime-kuma'.
+func normalizeName(value string) string {
+	return nonAlphanumeric.ReplaceAllString(strings.ToLower(value), "")
+}
+
+// newDetectedProfiles create a profile for every catalog app to use once the app of a response is detected.
+func newDetectedProfiles(config *Config, rule *AppRule) (map[string]*profile, error) {
+	result := make(map[string]*profile, len(catalogApps))
+
+	for _, app := range catalogApps {
+		appConfig := config.forDetectedApp(app)
+		appConfig.setDefaults()
+
+		appProfile, err := compileProfile(appConfig, rule)
+		if err != nil {
+			return nil, err
+		}
+
+		result[app] = appProfile
+	}
+
+	return result, nil
+}
+
+// forDetectedApp create a copy of an 'auto' config for app keeping only the addons app supports.
+func (config *Config) forDetectedApp(app string) *Config {
+	result := config.clone()
+	result.App = app
+	result.Addons = getSupportedAddons(app, config.Addons)
+
+	return result
+}
+
+// getSupportedAddons filter addons to the ones available for app.
+func getSupportedAddons(app string, addons []string) []string {
+	appConfig := &Config{App: app}
+	result := make([]string, 0, len(addons))
+
+	for _, addon := range addons {
+		if addonName := appConfig.getAddonName(addon); containsString(catalogAddons[app], addonName) {
+			result = append(result, addonName)
+		}
+	}
+
+	return result
+}
+
+// getAddonSuffixes get the names of all catalog addons without their app prefix.
+func getAddonSuffixes() []string {
+	result := make([]string, 0)
+
+	for app, addons := range catalogAddons {
+		for _, addon := range addons {
+			if suffix := strings.TrimPrefix(addon, app+"-"); !containsString(result, suffix) {
+				result = append(result, suffix)
+			}
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
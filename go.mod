@@ -2,4 +2,4 @@ module github.com/packruler/traefik-themepark
 
 go 1.16
 
-require github.com/packruler/rewrite-body v1.2.0
+require github.com/andybalholm/brotli v1.1.0
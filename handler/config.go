@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"regexp"
+
+	"github.com/packruler/traefik-themepark/httputil"
+)
+
+// Rewrite holds one rewrite body configuration.
+type Rewrite struct {
+	Regex       string `json:"regex" yaml:"regex" toml:"regex"`
+	Replacement string `json:"replacement" yaml:"replacement" toml:"replacement"`
+}
+
+// Config holds the plugin configuration.
+type Config struct {
+	LastModified bool                      `json:"lastModified" toml:"lastModified" yaml:"lastModified"`
+	Rewrites     []Rewrite                 `json:"rewrites" toml:"rewrites" yaml:"rewrites"`
+	LogLevel     int8                      `json:"logLevel" toml:"logLevel" yaml:"logLevel"`
+	Monitoring   httputil.MonitoringConfig `json:"monitoring" toml:"monitoring" yaml:"monitoring"`
+	// MaxBodySize limits in bytes how much of a body is buffered. Larger bodies are passed through unchanged.
+	MaxBodySize int64 `json:"maxBodySize" toml:"maxBodySize" yaml:"maxBodySize"`
+	// Streaming rewrites responses while they are received instead of buffering them when a stream rewriter is set.
+	Streaming   bool        `json:"streaming" toml:"streaming" yaml:"streaming"`
+	Compression Compression `json:"compression" toml:"compression" yaml:"compression"`
+	// MeasureHead answers monitored HEAD requests with the headers of the rewritten GET response, including its
+	// Content-Length, instead of removing the headers of the service that describe the body before rewriting.
+	MeasureHead bool `json:"measureHead" toml:"measureHead" yaml:"measureHead"`
+}
+
+// Compression holds the configuration for compressing rewritten bodies.
+type Compression struct {
+	// Negotiate requests uncompressed bodies from the service and compresses them with the encoding preferred
+	// by the client instead of the one used by the service, whether they are rewritten or not.
+	Negotiate bool `json:"negotiate" toml:"negotiate" yaml:"negotiate"`
+	// Level is the compression level of rewritten bodies on the scale of each algorithm, 0 uses their default.
+	// Levels above compressutil.MaxLevel are invalid.
+	Level int `json:"level" toml:"level" yaml:"level"`
+	// MinSize is the size in bytes below which negotiated bodies are sent uncompressed. Only bodies the service
+	// sent uncompressed are affected, and streamed bodies only when the service announces their Content-Length.
+	// Bodies the service compressed are always sent with its encoding since their header precedes the body.
+	// It requires Negotiate.
+	MinSize int64 `json:"minSize" toml:"minSize" yaml:"minSize"`
+}
+
+type rewrite struct {
+	regex       *regexp.Regexp
+	replacement []byte
+}
@@ -0,0 +1,382 @@
+// Package handler a plugin to rewrite response body.
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/packruler/traefik-themepark/compressutil"
+	"github.com/packruler/traefik-themepark/httputil"
+	"github.com/packruler/traefik-themepark/logger"
+)
+
+type rewriteBody struct {
+	name             string
+	next             http.Handler
+	rewrites         []rewrite
+	lastModified     bool
+	logger           logger.LogWriter
+	monitoringConfig httputil.MonitoringConfig
+	rewriter         BodyRewriter
+	maxBodySize      int64
+	streaming        bool
+	streamRewriter   StreamRewriterFactory
+	compression      Compression
+	variant          VariantFunc
+	measureHead      bool
+	headCache        *headCache
+}
+
+// BodyRewriter rewrites the decoded response body of the provided request.
+type BodyRewriter func(req *http.Request, body []byte) []byte
+
+// StreamRewriterFactory creates the httputil.StreamRewriter for the provided request,
+// or nil when the response must be buffered and rewritten as a whole.
+type StreamRewriterFactory func(req *http.Request) httputil.StreamRewriter
+
+// VariantFunc identifies how the body of the provided request is rewritten, or returns an empty string when it is
+// not. The variant is added to the ETag of rewritten responses so clients revalidate them once it changes.
+type VariantFunc func(req *http.Request) string
+
+// Option allows customizing the handler returned by New.
+type Option func(*rewriteBody)
+
+// WithRewriter applies rewriter to every processed body after the configured rewrites.
+func WithRewriter(rewriter BodyRewriter) Option {
+	return func(bodyRewrite *rewriteBody) {
+		bodyRewrite.rewriter = rewriter
+	}
+}
+
+// WithStreamRewriter rewrites bodies while they are streamed when streaming is enabled.
+// It replaces the BodyRewriter for requests factory creates a StreamRewriter for.
+func WithStreamRewriter(factory StreamRewriterFactory) Option {
+	return func(bodyRewrite *rewriteBody) {
+		bodyRewrite.streamRewriter = factory
+	}
+}
+
+// WithVariant derives the ETag of rewritten responses from the ETag of the service and variant.
+func WithVariant(variant VariantFunc) Option {
+	return func(bodyRewrite *rewriteBody) {
+		bodyRewrite.variant = variant
+	}
+}
+
+// New creates and returns a new rewrite body plugin instance.
+func New(_ context.Context, next http.Handler, config *Config, name string, options ...Option) (http.Handler, error) {
+	rewrites := make([]rewrite, len(config.Rewrites))
+
+	for index, rewriteConfig := range config.Rewrites {
+		regex, err := regexp.Compile(rewriteConfig.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling regex %q: %w", rewriteConfig.Regex, err)
+		}
+
+		rewrites[index] = rewrite{
+			regex:       regex,
+			replacement: []byte(rewriteConfig.Replacement),
+		}
+	}
+
+	logWriter := *logger.CreateLogger(logger.LogLevel(config.LogLevel))
+
+	if err := validateCompression(config.Compression, logWriter); err != nil {
+		return nil, err
+	}
+
+	config.Monitoring.EnsureDefaults()
+	config.Monitoring.EnsureProperFormat()
+
+	if err := config.Monitoring.ParseStatusCodes(); err != nil {
+		return nil, err
+	}
+
+	if err := config.Monitoring.ParsePaths(); err != nil {
+		return nil, err
+	}
+
+	result := &rewriteBody{
+		name:             name,
+		next:             next,
+		rewrites:         rewrites,
+		lastModified:     config.LastModified,
+		logger:           logWriter,
+		monitoringConfig: config.Monitoring,
+		maxBodySize:      config.MaxBodySize,
+		streaming:        config.Streaming,
+		compression:      config.Compression,
+		measureHead:      config.MeasureHead,
+		headCache:        newHeadCache(),
+	}
+
+	for _, option := range options {
+		option(result)
+	}
+
+	data, _ := json.Marshal(config)
+
+	logWriter.LogDebugf("Initial config: %v", string(data))
+
+	return result, nil
+}
+
+// validateCompression check that compression can be applied as configured. Levels follow the scale of each
+// algorithm so a level only capped by some of them is accepted with a warning.
+func validateCompression(compression Compression, logWriter logger.LogWriter) error {
+	if compression.Level < 0 || compression.MinSize < 0 {
+		return fmt.Errorf(
+			"invalid compression level %d or minSize %d: values must not be negative",
+			compression.Level,
+			compression.MinSize,
+		)
+	}
+
+	if compression.Level > compressutil.MaxLevel {
+		return fmt.Errorf(
+			"invalid compression level %d: the highest level is %d",
+			compression.Level,
+			compressutil.MaxLevel,
+		)
+	}
+
+	if compression.MinSize > 0 && !compression.Negotiate {
+		return fmt.Errorf("invalid compression minSize %d: minSize requires negotiate", compression.MinSize)
+	}
+
+	if maxLevel := compressutil.GetMaxLevel(compressutil.Gzip); compression.Level > maxLevel {
+		logWriter.LogWarningf("Compression level %d is capped at %d for gzip and deflate", compression.Level, maxLevel)
+	}
+
+	if maxLevel := compressutil.GetMaxLevel(compressutil.Brotli); compression.Level > maxLevel {
+		logWriter.LogWarningf("Compression level %d is capped at %d for brotli", compression.Level, maxLevel)
+	}
+
+	return nil
+}
+
+func (bodyRewrite *rewriteBody) ServeHTTP(response http.ResponseWriter, req *http.Request) {
+	aborted := false
+
+	// The server aborts the connection of truncated responses so clients can not take them for complete ones.
+	// This runs after handlePanic so the panic is not recovered there.
+	defer func() {
+		if aborted {
+			panic(http.ErrAbortHandler)
+		}
+	}()
+	defer bodyRewrite.handlePanic()
+
+	wrappedRequest := httputil.WrapRequest(req, bodyRewrite.monitoringConfig, bodyRewrite.logger)
+	// allow default http.ResponseWriter to handle calls targeting WebSocket upgrades and non GET methods
+	if !wrappedRequest.SupportsProcessing() {
+		bodyRewrite.logger.LogDebugf("Ignoring unsupported request: %v", req)
+		bodyRewrite.next.ServeHTTP(response, req)
+
+		return
+	}
+
+	bodyRewrite.logger.LogDebugf("Starting supported request: %v", req)
+
+	if req.Method == http.MethodHead && bodyRewrite.measureHead {
+		bodyRewrite.serveHead(response, wrappedRequest)
+
+		return
+	}
+
+	if req.Method == http.MethodHead {
+		bodyRewrite.serveHeadUnmeasured(response, wrappedRequest)
+
+		return
+	}
+
+	if streamRewriter := bodyRewrite.getStreamRewriter(req); streamRewriter != nil {
+		aborted = bodyRewrite.serveStream(response, wrappedRequest, streamRewriter)
+
+		return
+	}
+
+	wrappedWriter := httputil.WrapWriter(
+		response,
+		bodyRewrite.monitoringConfig,
+		bodyRewrite.logger,
+		bodyRewrite.lastModified,
+	)
+	defer wrappedWriter.Release()
+
+	wrappedWriter.SetLastModified(bodyRewrite.lastModified)
+	wrappedWriter.SetMaxBodySize(bodyRewrite.maxBodySize)
+
+	// look into using https://pkg.go.dev/net/http#RoundTripper
+	bodyRewrite.next.ServeHTTP(wrappedWriter, bodyRewrite.prepare(wrappedRequest, wrappedWriter))
+
+	if wrappedWriter.IsNotModified() {
+		// The client already has the rewritten body.
+		return
+	}
+
+	if wrappedWriter.IsPassthrough() {
+		// The body was too large to buffer and has already been written unchanged.
+		if err := wrappedWriter.Close(); err != nil {
+			bodyRewrite.logger.LogErrorf("unable to close encoder: %v", err)
+		}
+
+		return
+	}
+
+	if !wrappedWriter.SupportsProcessing() {
+		bodyRewrite.logger.LogDebugf("Ignoring unsupported response: %v", wrappedWriter)
+
+		if wrappedWriter.IsReencoding() {
+			// The service was asked for an uncompressed body so it is still encoded for the client.
+			wrappedWriter.SetContent(wrappedWriter.GetBuffer().Bytes(), wrappedWriter.GetContentFormat())
+
+			return
+		}
+
+		// We are ignoring these any errors because the content should be unchanged here.
+		// This could "error" if writing is not supported but content will return properly.
+		_, _ = response.Write(wrappedWriter.GetBuffer().Bytes())
+
+		return
+	}
+
+	bodyBytes, err := wrappedWriter.GetContent()
+	if err != nil {
+		bodyRewrite.logger.LogErrorf("Error loading content: %v", err)
+
+		if _, err := response.Write(wrappedWriter.GetBuffer().Bytes()); err != nil {
+			bodyRewrite.logger.LogErrorf("unable to write error content: %v", err)
+		}
+
+		return
+	}
+
+	bodyRewrite.logger.LogDebugf("Response body: %s", bodyBytes)
+
+	if len(bodyBytes) == 0 {
+		// If the body is empty there is no purpose in continuing this process.
+		if wrappedWriter.IsReencoding() {
+			// An empty body still has to be valid for the Content-Encoding announced to the client.
+			wrappedWriter.SetContent(bodyBytes, wrappedWriter.GetContentFormat())
+		}
+
+		return
+	}
+
+	for _, rwt := range bodyRewrite.rewrites {
+		bodyBytes = rwt.regex.ReplaceAll(bodyBytes, rwt.replacement)
+	}
+
+	if bodyRewrite.rewriter != nil {
+		bodyBytes = bodyRewrite.rewriter(req, bodyBytes)
+	}
+
+	bodyRewrite.logger.LogDebugf("Transformed body: %s", bodyBytes)
+
+	wrappedWriter.SetContent(bodyBytes, wrappedWriter.GetContentFormat())
+}
+
+// getStreamRewriter get the StreamRewriter for req if the response can be rewritten while it is streamed.
+func (bodyRewrite *rewriteBody) getStreamRewriter(req *http.Request) httputil.StreamRewriter {
+	// Configured rewrites are regular expressions that need the whole body.
+	if !bodyRewrite.streaming || bodyRewrite.streamRewriter == nil || len(bodyRewrite.rewrites) > 0 {
+		return nil
+	}
+
+	return bodyRewrite.streamRewriter(req)
+}
+
+// serveStream rewrite the response while it is streamed and report whether it has to be aborted.
+func (bodyRewrite *rewriteBody) serveStream(
+	response http.ResponseWriter,
+	wrappedRequest *httputil.RequestWrapper,
+	streamRewriter httputil.StreamRewriter,
+) bool {
+	streamWriter := httputil.WrapStreamWriter(
+		response,
+		streamRewriter,
+		bodyRewrite.monitoringConfig,
+		bodyRewrite.logger,
+		bodyRewrite.lastModified,
+	)
+	// Rewriting is stopped even if the service panics, only the error once it is done matters.
+	defer func() { _ = streamWriter.Close() }()
+
+	bodyRewrite.next.ServeHTTP(streamWriter, bodyRewrite.prepare(wrappedRequest, streamWriter))
+
+	return errors.Is(streamWriter.Close(), httputil.ErrStreamAborted)
+}
+
+// responseSettings the settings shared by the buffered and streamed response wrappers.
+type responseSettings interface {
+	SetEncodingTarget(target string)
+	SetCompressionLevel(level int)
+	SetMinSize(value int64)
+	SetETagVariant(getVariant func() string, ifNoneMatch string)
+}
+
+// prepare get the request sent to the service for wrappedRequest and configure writer for its response.
+// Without negotiation the service picks an encoding this plugin supports and the rewritten body keeps it.
+func (bodyRewrite *rewriteBody) prepare(
+	wrappedRequest *httputil.RequestWrapper,
+	writer responseSettings,
+) *http.Request {
+	upstreamRequest := wrappedRequest.CloneWithSupportedEncoding()
+
+	if bodyRewrite.compression.Negotiate {
+		upstreamRequest = wrappedRequest.CloneNoEncode()
+
+		writer.SetEncodingTarget(wrappedRequest.GetEncodingTarget())
+	}
+
+	writer.SetCompressionLevel(bodyRewrite.compression.Level)
+	writer.SetMinSize(bodyRewrite.compression.MinSize)
+
+	if bodyRewrite.variant != nil {
+		getVariant := bodyRewrite.getLazyVariant(&wrappedRequest.Request)
+
+		httputil.TranslateIfNoneMatch(upstreamRequest.Header, getVariant)
+		writer.SetETagVariant(getVariant, wrappedRequest.Header.Get("If-None-Match"))
+	}
+
+	return upstreamRequest
+}
+
+// getLazyVariant get a function returning the variant of req, which is only computed once it is first needed.
+func (bodyRewrite *rewriteBody) getLazyVariant(req *http.Request) func() string {
+	computed := false
+	variant := ""
+
+	return func() string {
+		if !computed {
+			variant = bodyRewrite.variant(req)
+			computed = true
+		}
+
+		return variant
+	}
+}
+
+func (bodyRewrite *rewriteBody) handlePanic() {
+	if recovery := recover(); recovery != nil {
+		if err, ok := recovery.(error); ok {
+			bodyRewrite.logError(err)
+		} else {
+			bodyRewrite.logger.LogWarningf("Unhandled error: %v", recovery)
+		}
+	}
+}
+
+func (bodyRewrite *rewriteBody) logError(err error) {
+	// Ignore http.ErrAbortHandler because they are expected errors that do not require handling
+	if errors.Is(err, http.ErrAbortHandler) {
+		return
+	}
+
+	bodyRewrite.logger.LogWarningf("Recovered from: %v", err)
+}
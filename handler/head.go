@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/packruler/traefik-themepark/httputil"
+)
+
+// maxHeadCacheEntries limits how many response sizes are remembered for HEAD requests.
+const maxHeadCacheEntries = 1024
+
+// rewrittenHeaders the headers of a response that depend on the rewritten body. The other headers, like
+// Set-Cookie, are taken from the response of the service to the HEAD request itself.
+var rewrittenHeaders = []string{"Content-Length", "Content-Encoding", "Content-Type", "ETag", "Vary"}
+
+// headEntry the rewritten headers of a response and the ETag of the service they are valid for.
+type headEntry struct {
+	etag   string
+	header http.Header
+}
+
+// headCache remembers the headers of rewritten responses so HEAD requests do not need to fetch the whole body
+// while the ETag of the service is unchanged.
+type headCache struct {
+	lock    sync.Mutex
+	entries map[string]headEntry
+}
+
+func newHeadCache() *headCache {
+	return &headCache{entries: make(map[string]headEntry)}
+}
+
+func (cache *headCache) get(key string, etag string) (http.Header, bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	entry, ok := cache.entries[key]
+	if !ok || entry.etag != etag {
+		return nil, false
+	}
+
+	return entry.header, true
+}
+
+func (cache *headCache) set(key string, etag string, header http.Header) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if _, ok := cache.entries[key]; !ok && len(cache.entries) >= maxHeadCacheEntries {
+		// Starting over is good enough since entries are cheap to recreate.
+		cache.entries = make(map[string]headEntry)
+	}
+
+	cache.entries[key] = headEntry{etag: etag, header: header}
+}
+
+// headWriter records a response without its body, only counting its length.
+type headWriter struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	size        int64
+}
+
+func (writer *headWriter) Header() http.Header {
+	return writer.header
+}
+
+func (writer *headWriter) WriteHeader(statusCode int) {
+	// Informational responses are not part of the headers of the final response.
+	if writer.wroteHeader || statusCode < http.StatusOK {
+		return
+	}
+
+	writer.wroteHeader = true
+	writer.status = statusCode
+}
+
+func (writer *headWriter) Write(data []byte) (int, error) {
+	writer.WriteHeader(http.StatusOK)
+	writer.size += int64(len(data))
+
+	return len(data), nil
+}
+
+// Flush does nothing since the body is not sent.
+func (writer *headWriter) Flush() {}
+
+// serveHead answer a HEAD request with the headers, including the Content-Length, the rewritten response to
+// the matching GET request would have. The headers are reused while the ETag of the service is unchanged,
+// otherwise the page is requested and rewritten to measure it.
+func (bodyRewrite *rewriteBody) serveHead(response http.ResponseWriter, wrappedRequest *httputil.RequestWrapper) {
+	req := &wrappedRequest.Request
+	service := bodyRewrite.headService(response.Header().Clone(), wrappedRequest)
+
+	if !bodyRewrite.monitoringConfig.SupportsStatusCode(service.status) ||
+		!httputil.SupportsResponse(service.header, bodyRewrite.monitoringConfig) {
+		// Responses that are not rewritten keep the headers of the service.
+		copyHeader(response.Header(), service.header)
+		response.WriteHeader(service.status)
+
+		return
+	}
+
+	key := bodyRewrite.getHeadCacheKey(req)
+	etag := ""
+
+	// Conditional requests are answered by the rewriting itself.
+	if service.status == http.StatusOK && req.Header.Get("If-None-Match") == "" &&
+		req.Header.Get("If-Modified-Since") == "" {
+		etag = service.header.Get("ETag")
+
+		if header, ok := bodyRewrite.headCache.get(key, etag); ok && etag != "" {
+			copyHeader(response.Header(), service.header)
+			copyRewrittenHeader(response.Header(), header)
+			response.WriteHeader(http.StatusOK)
+
+			return
+		}
+	}
+
+	getRequest := req.Clone(req.Context())
+	getRequest.Method = http.MethodGet
+
+	writer := &headWriter{header: response.Header().Clone(), status: http.StatusOK}
+	bodyRewrite.ServeHTTP(writer, getRequest)
+
+	if writer.status >= http.StatusOK && writer.status != http.StatusNoContent && writer.status != http.StatusNotModified {
+		writer.header.Set("Content-Length", strconv.FormatInt(writer.size, 10))
+	}
+
+	if etag != "" && writer.status == http.StatusOK {
+		header := make(http.Header)
+		copyRewrittenHeader(header, writer.header)
+		bodyRewrite.headCache.set(key, etag, header)
+	}
+
+	copyHeader(response.Header(), service.header)
+	copyRewrittenHeader(response.Header(), writer.header)
+	response.WriteHeader(writer.status)
+}
+
+// serveHeadUnmeasured answer a HEAD request with the headers of the service, leaving out the ones describing
+// the body before it is rewritten since the length of the rewritten body is unknown without fetching it.
+func (bodyRewrite *rewriteBody) serveHeadUnmeasured(
+	response http.ResponseWriter,
+	wrappedRequest *httputil.RequestWrapper,
+) {
+	service := bodyRewrite.headService(response.Header().Clone(), wrappedRequest)
+	copyHeader(response.Header(), service.header)
+
+	if bodyRewrite.monitoringConfig.SupportsStatusCode(service.status) &&
+		httputil.SupportsResponse(service.header, bodyRewrite.monitoringConfig) {
+		response.Header().Del("Content-Length")
+		response.Header().Del("ETag")
+		response.Header().Del("Accept-Ranges")
+
+		if !bodyRewrite.lastModified {
+			response.Header().Del("Last-Modified")
+		}
+	}
+
+	response.WriteHeader(service.status)
+}
+
+// headService send the HEAD request of wrappedRequest, without its conditions, to the service
+// and record its response on top of header.
+func (bodyRewrite *rewriteBody) headService(header http.Header, wrappedRequest *httputil.RequestWrapper) *headWriter {
+	headRequest := wrappedRequest.CloneWithSupportedEncoding()
+	headRequest.Header.Del("If-None-Match")
+	headRequest.Header.Del("If-Modified-Since")
+
+	writer := &headWriter{header: header, status: http.StatusOK}
+	bodyRewrite.next.ServeHTTP(writer, headRequest)
+
+	return writer
+}
+
+// getHeadCacheKey get the key of the headers of the response to req, which depend on how it is rewritten
+// and the encodings accepted by the client.
+func (bodyRewrite *rewriteBody) getHeadCacheKey(req *http.Request) string {
+	variant := ""
+	if bodyRewrite.variant != nil {
+		variant = bodyRewrite.variant(req)
+	}
+
+	return req.Host + req.URL.String() + "\n" + variant + "\n" + req.Header.Get("Accept-Encoding")
+}
+
+// copyRewrittenHeader replace the rewrittenHeaders of destination with those of source.
+func copyRewrittenHeader(destination http.Header, source http.Header) {
+	for _, name := range rewrittenHeaders {
+		destination.Del(name)
+
+		if values := source.Values(name); len(values) > 0 {
+			destination[name] = append([]string(nil), values...)
+		}
+	}
+}
+
+// copyHeader replace the values of destination with those of source.
+func copyHeader(destination http.Header, source http.Header) {
+	for name, values := range source {
+		destination[name] = append([]string(nil), values...)
+	}
+}
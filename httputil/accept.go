@@ -0,0 +1,170 @@
+package httputil
+
+import (
+	"strconv"
+	"strings"
+)
+
+// mediaRange a media range of an Accept header with its quality.
+type mediaRange struct {
+	mainType   string
+	subType    string
+	parameters []string
+	quality    float64
+}
+
+// specificity get how specific the media range is. The most specific media range matching a media type
+// determines its quality.
+func (accept mediaRange) specificity() int {
+	switch {
+	case accept.mainType == "*":
+		return 0
+	case accept.subType == "*":
+		return 1
+	default:
+		return 2 + len(accept.parameters)
+	}
+}
+
+// matches check if the media range includes the media type mainType/subType with parameters, given as
+// name=value pairs. A media range with parameters only includes media types that have each of them.
+func (accept mediaRange) matches(mainType string, subType string, parameters []string) bool {
+	for _, parameter := range accept.parameters {
+		if !containsParameter(parameters, parameter) {
+			return false
+		}
+	}
+
+	if accept.mainType == "*" {
+		return true
+	}
+
+	return accept.mainType == mainType && (accept.subType == "*" || accept.subType == subType)
+}
+
+// containsParameter check if parameters contains the name=value pair parameter.
+func containsParameter(parameters []string, parameter string) bool {
+	for _, candidate := range parameters {
+		if candidate == parameter {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseAccept get the media ranges listed in an Accept header value. Invalid media ranges are ignored.
+func parseAccept(value string) []mediaRange {
+	result := make([]mediaRange, 0)
+
+	for _, item := range strings.Split(value, ",") {
+		split := strings.Split(item, ";")
+
+		mainType, subType, ok := splitMediaType(split[0])
+		if !ok || (mainType == "*" && subType != "*") {
+			continue
+		}
+
+		accept := mediaRange{mainType: mainType, subType: subType, parameters: make([]string, 0), quality: 1.0}
+
+		for _, parameter := range split[1:] {
+			name, value := splitParameter(parameter)
+			if name != "q" {
+				accept.parameters = append(accept.parameters, name+"="+value)
+
+				continue
+			}
+
+			targetFloat := 64
+
+			// Parameters after the quality are accept extensions rather than part of the media range.
+			if parsedQuality, err := strconv.ParseFloat(value, targetFloat); err == nil {
+				accept.quality = parsedQuality
+			}
+
+			break
+		}
+
+		result = append(result, accept)
+	}
+
+	return result
+}
+
+// splitMediaType get the lower case type and subtype of a media type or range.
+func splitMediaType(mediaType string) (string, string, bool) {
+	split := strings.Split(strings.ToLower(strings.TrimSpace(mediaType)), "/")
+	if len(split) != 2 || split[0] == "" || split[1] == "" {
+		return "", "", false
+	}
+
+	return strings.TrimSpace(split[0]), strings.TrimSpace(split[1]), true
+}
+
+// getParameters get the parameters of a media type as name=value pairs.
+func getParameters(mediaType string) []string {
+	parameters := make([]string, 0)
+
+	for _, parameter := range strings.Split(mediaType, ";")[1:] {
+		name, value := splitParameter(parameter)
+		parameters = append(parameters, name+"="+value)
+	}
+
+	return parameters
+}
+
+// splitParameter get the lower case name and the value of a media type parameter.
+func splitParameter(parameter string) (string, string) {
+	split := strings.SplitN(parameter, "=", 2)
+	name := strings.ToLower(strings.TrimSpace(split[0]))
+
+	if len(split) == 1 {
+		return name, ""
+	}
+
+	return name, strings.Trim(strings.TrimSpace(split[1]), `"`)
+}
+
+// acceptsMediaType check if the media ranges of an Accept header accept mediaType with a quality above 0.
+// A media type only accepted through '*/*' is accepted when acceptWildcard is set.
+func acceptsMediaType(ranges []mediaRange, mediaType string, acceptWildcard bool) bool {
+	mainType, subType, ok := splitMediaType(strings.Split(mediaType, ";")[0])
+	if !ok {
+		return false
+	}
+
+	parameters := getParameters(mediaType)
+
+	var best *mediaRange
+
+	for index := range ranges {
+		accept := &ranges[index]
+		if accept.matches(mainType, subType, parameters) && (best == nil || accept.specificity() > best.specificity()) {
+			best = accept
+		}
+	}
+
+	if best == nil || best.quality <= 0 {
+		return false
+	}
+
+	return best.mainType != "*" || acceptWildcard
+}
+
+// acceptsAnyType check if an Accept header value accepts any of the mediaTypes. Requests without an Accept
+// header accept any media type.
+func acceptsAnyType(value string, mediaTypes []string, acceptWildcard bool) bool {
+	if strings.TrimSpace(value) == "" {
+		value = "*/*"
+	}
+
+	ranges := parseAccept(value)
+
+	for _, mediaType := range mediaTypes {
+		if acceptsMediaType(ranges, mediaType, acceptWildcard) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,50 @@
+package httputil
+
+import (
+	"net/http"
+
+	"github.com/packruler/traefik-themepark/compressutil"
+)
+
+// canReencode check if a response can be encoded with target instead of the service's encoding.
+// Only uncompressed responses are re-encoded, whether they are rewritten or not, since the service was asked
+// for an uncompressed body on behalf of a client that may accept target.
+func canReencode(header http.Header, statusCode int, target string) bool {
+	if target == "" || !isBodyAllowed(statusCode) {
+		return false
+	}
+
+	switch header.Get("Content-Encoding") {
+	case "", compressutil.Identity:
+		return true
+	default:
+		// The service ignored the request for an uncompressed body so its encoding is kept.
+		return false
+	}
+}
+
+// getReencoding get the encoding a re-encoded body of size bytes is sent with. Bodies smaller than minSize
+// are sent uncompressed while bodies of unknown size, given as a negative size, are always encoded with target.
+func getReencoding(target string, size int64, minSize int64) string {
+	if size >= 0 && size < minSize {
+		return compressutil.Identity
+	}
+
+	return target
+}
+
+// setEncodingHeaders update header for a body re-encoded with encoding.
+func setEncodingHeaders(header http.Header, encoding string) {
+	header.Add("Vary", "Accept-Encoding")
+
+	if encoding == compressutil.Identity {
+		header.Del("Content-Encoding")
+	} else {
+		header.Set("Content-Encoding", encoding)
+	}
+}
+
+// isBodyAllowed check if a response with statusCode may have a body.
+func isBodyAllowed(statusCode int) bool {
+	return statusCode >= http.StatusOK && statusCode != http.StatusNoContent && statusCode != http.StatusNotModified
+}
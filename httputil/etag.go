@@ -0,0 +1,148 @@
+package httputil
+
+import (
+	"net/http"
+	"strings"
+)
+
+// etagVariantSeparator separates the ETag of the service from the variant in derived ETags.
+const etagVariantSeparator = "-tp"
+
+// TranslateIfNoneMatch replace the ETags derived for the variant returned by getVariant in the If-None-Match
+// header of a request with the ETags of the service they were derived from. Other ETags can not match the
+// rewritten response and are removed. getVariant is only called when the request has an If-None-Match header.
+func TranslateIfNoneMatch(header http.Header, getVariant func() string) {
+	ifNoneMatch := header.Get("If-None-Match")
+	if ifNoneMatch == "" {
+		return
+	}
+
+	variant := getVariant()
+	if variant == "" {
+		return
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since, which would ignore a change of the variant.
+	header.Del("If-Modified-Since")
+
+	suffix := etagVariantSeparator + variant
+	translated := make([]string, 0)
+
+	for _, tag := range parseETags(ifNoneMatch) {
+		if tag == "*" {
+			translated = append(translated, tag)
+
+			continue
+		}
+
+		opaque := getOpaqueTag(tag)
+		if !strings.HasSuffix(opaque, suffix) {
+			continue
+		}
+
+		// Derived ETags are weak so the ETag of the service may have been either strong or weak.
+		original := strings.TrimSuffix(opaque, suffix)
+		translated = append(translated, `"`+original+`"`, `W/"`+original+`"`)
+	}
+
+	if len(translated) == 0 {
+		header.Del("If-None-Match")
+
+		return
+	}
+
+	header.Set("If-None-Match", strings.Join(translated, ", "))
+}
+
+// deriveETag replace the ETag of a response rewritten for the variant returned by getVariant and check if the
+// client already has it according to ifNoneMatch, the If-None-Match header of its request. getVariant is only
+// called when the response has an ETag to derive from.
+func deriveETag(
+	header http.Header,
+	statusCode int,
+	getVariant func() string,
+	ifNoneMatch string,
+	monitoring MonitoringConfig,
+) bool {
+	etag := header.Get("ETag")
+	if getVariant == nil || etag == "" {
+		return false
+	}
+
+	// Not modified responses have no body to check but stand for the rewritten response.
+	if statusCode != http.StatusNotModified && !supportsRewriting(statusCode, header, monitoring) {
+		return false
+	}
+
+	variant := getVariant()
+	if variant == "" {
+		return false
+	}
+
+	derived := `W/"` + getOpaqueTag(etag) + etagVariantSeparator + variant + `"`
+	header.Set("ETag", derived)
+
+	return statusCode == http.StatusOK && matchesETag(ifNoneMatch, derived)
+}
+
+// matchesETag check if the If-None-Match header value ifNoneMatch matches etag using the weak comparison.
+func matchesETag(ifNoneMatch string, etag string) bool {
+	for _, tag := range parseETags(ifNoneMatch) {
+		if tag == "*" || getOpaqueTag(tag) == getOpaqueTag(etag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseETags get the ETags listed in an If-None-Match header value. ETags may contain commas so the quotes
+// are followed rather than splitting the value.
+func parseETags(value string) []string {
+	tags := make([]string, 0)
+
+	for {
+		value = strings.TrimLeft(value, " \t,")
+		if value == "" {
+			return tags
+		}
+
+		if value[0] == '*' {
+			tags = append(tags, "*")
+			value = value[1:]
+
+			continue
+		}
+
+		start := 0
+		if strings.HasPrefix(value, "W/") {
+			start = len("W/")
+		}
+
+		if len(value) <= start || value[start] != '"' {
+			// Skip anything that is not an ETag up to the next entry.
+			next := strings.IndexByte(value, ',')
+			if next < 0 {
+				return tags
+			}
+
+			value = value[next:]
+
+			continue
+		}
+
+		end := strings.IndexByte(value[start+1:], '"')
+		if end < 0 {
+			return tags
+		}
+
+		end += start + 2
+		tags = append(tags, value[:end])
+		value = value[end:]
+	}
+}
+
+// getOpaqueTag get the value of tag without its weakness indicator and quotes.
+func getOpaqueTag(tag string) string {
+	return strings.Trim(strings.TrimPrefix(tag, "W/"), `"`)
+}
@@ -0,0 +1,227 @@
+package httputil
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MonitoringConfig structure of data for handling configuration for
+// controlling what content is monitored.
+type MonitoringConfig struct {
+	Types   []string `json:"types,omitempty" yaml:"types,omitempty" toml:"types,omitempty" export:"true"`
+	Methods []string `json:"methods,omitempty" yaml:"methods,omitempty" toml:"methods,omitempty" export:"true"`
+	// StatusCodes lists the status codes and ranges of responses that are processed, e.g. '200-299,404'.
+	// Every status code is processed when empty.
+	StatusCodes string `json:"statusCodes,omitempty" yaml:"statusCodes,omitempty" toml:"statusCodes,omitempty" export:"true"` //nolint:lll // Struct tags can not be wrapped.
+	// AcceptWildcard processes requests accepting Types only through '*/*', like the default Accept header of
+	// curl and fetch(), or without an Accept header.
+	AcceptWildcard bool `json:"acceptWildcard,omitempty" yaml:"acceptWildcard,omitempty" toml:"acceptWildcard,omitempty" export:"true"` //nolint:lll // Struct tags can not be wrapped.
+
+	// IncludePaths limits processing to requests with a path matching one of these patterns when set.
+	IncludePaths []string `json:"includePaths,omitempty" yaml:"includePaths,omitempty" toml:"includePaths,omitempty" export:"true"` //nolint:lll // Struct tags can not be wrapped.
+	// ExcludePaths skips processing for requests with a path matching one of these patterns.
+	ExcludePaths []string `json:"excludePaths,omitempty" yaml:"excludePaths,omitempty" toml:"excludePaths,omitempty" export:"true"` //nolint:lll // Struct tags can not be wrapped.
+
+	statusRanges []statusRange
+	includePaths []*regexp.Regexp
+	excludePaths []*regexp.Regexp
+}
+
+// statusRange an inclusive range of status codes.
+type statusRange struct {
+	from int
+	to   int
+}
+
+// EnsureDefaults check Types and Methods for empty arrays and apply default values if found.
+func (config *MonitoringConfig) EnsureDefaults() {
+	if len(config.Methods) == 0 {
+		config.Methods = []string{http.MethodGet}
+	}
+
+	if len(config.Types) == 0 {
+		// 'application/xhtml+xml' is left out since browsers parse it as XML, where markup that is valid HTML but
+		// not well-formed XML, like unclosed link tags, breaks the whole page.
+		config.Types = []string{"text/html"}
+	}
+}
+
+// EnsureProperFormat handle weird yaml parsing until the underlying issue can be resolved.
+func (config *MonitoringConfig) EnsureProperFormat() {
+	if len(config.Methods) == 1 && strings.HasPrefix(config.Methods[0], "║24║") {
+		config.Methods = strings.Split(strings.ReplaceAll(config.Methods[0], "║24║", ""), "║")
+	}
+
+	if len(config.Types) == 1 && strings.HasPrefix(config.Types[0], "║24║") {
+		config.Types = strings.Split(strings.ReplaceAll(config.Types[0], "║24║", ""), "║")
+	}
+
+	if len(config.IncludePaths) == 1 && strings.HasPrefix(config.IncludePaths[0], "║24║") {
+		config.IncludePaths = strings.Split(strings.ReplaceAll(config.IncludePaths[0], "║24║", ""), "║")
+	}
+
+	if len(config.ExcludePaths) == 1 && strings.HasPrefix(config.ExcludePaths[0], "║24║") {
+		config.ExcludePaths = strings.Split(strings.ReplaceAll(config.ExcludePaths[0], "║24║", ""), "║")
+	}
+}
+
+// ParseStatusCodes load the ranges of status codes listed in StatusCodes.
+func (config *MonitoringConfig) ParseStatusCodes() error {
+	config.statusRanges = nil
+
+	if strings.TrimSpace(config.StatusCodes) == "" {
+		return nil
+	}
+
+	for _, item := range strings.Split(config.StatusCodes, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(item), "-", 2)
+
+		from, err := parseStatusCode(bounds[0])
+		if err != nil {
+			return fmt.Errorf("invalid statusCodes %q: %w", config.StatusCodes, err)
+		}
+
+		to := from
+
+		if len(bounds) == 2 {
+			to, err = parseStatusCode(bounds[1])
+			if err != nil {
+				return fmt.Errorf("invalid statusCodes %q: %w", config.StatusCodes, err)
+			}
+		}
+
+		if to < from {
+			return fmt.Errorf("invalid statusCodes %q: range %q ends before it starts", config.StatusCodes, item)
+		}
+
+		config.statusRanges = append(config.statusRanges, statusRange{from: from, to: to})
+	}
+
+	return nil
+}
+
+func parseStatusCode(value string) (int, error) {
+	const (
+		minStatusCode = 100
+		maxStatusCode = 599
+	)
+
+	statusCode, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || statusCode < minStatusCode || statusCode > maxStatusCode {
+		return 0, fmt.Errorf("%q is not a status code", strings.TrimSpace(value))
+	}
+
+	return statusCode, nil
+}
+
+// SupportsStatusCode check if responses with statusCode are processed. Informational, no content and not
+// modified responses have no body to process so they are never processed.
+func (config *MonitoringConfig) SupportsStatusCode(statusCode int) bool {
+	if !isBodyAllowed(statusCode) {
+		return false
+	}
+
+	if len(config.statusRanges) == 0 {
+		return true
+	}
+
+	for _, statusRange := range config.statusRanges {
+		if statusCode >= statusRange.from && statusCode <= statusRange.to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isInformational check if statusCode is an informational response sent ahead of the final response.
+func isInformational(statusCode int) bool {
+	return statusCode >= http.StatusContinue && statusCode < http.StatusOK
+}
+
+// regexPathPrefix marks path patterns that are regular expressions instead of globs.
+const regexPathPrefix = "regex:"
+
+// ParsePaths compile the patterns of IncludePaths and ExcludePaths.
+func (config *MonitoringConfig) ParsePaths() error {
+	var err error
+
+	if config.includePaths, err = compilePathPatterns(config.IncludePaths); err != nil {
+		return fmt.Errorf("invalid includePaths: %w", err)
+	}
+
+	if config.excludePaths, err = compilePathPatterns(config.ExcludePaths); err != nil {
+		return fmt.Errorf("invalid excludePaths: %w", err)
+	}
+
+	return nil
+}
+
+// SupportsPath check if requests for requestPath are processed.
+func (config *MonitoringConfig) SupportsPath(requestPath string) bool {
+	if len(config.includePaths) > 0 && !matchesAnyPath(config.includePaths, requestPath) {
+		return false
+	}
+
+	return !matchesAnyPath(config.excludePaths, requestPath)
+}
+
+func matchesAnyPath(patterns []*regexp.Regexp, requestPath string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(requestPath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compilePathPatterns compile path patterns, either a regular expression with the 'regex:' prefix or a glob.
+func compilePathPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	result := make([]*regexp.Regexp, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		expression := globToRegex(pattern)
+		if strings.HasPrefix(pattern, regexPathPrefix) {
+			expression = strings.TrimPrefix(pattern, regexPathPrefix)
+		}
+
+		compiled, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling pattern %q: %w", pattern, err)
+		}
+
+		result = append(result, compiled)
+	}
+
+	return result, nil
+}
+
+// globToRegex get the regular expression matching whole paths like glob. '*' matches within a path segment,
+// '**' matches across segments and '?' matches a single character other than '/'.
+func globToRegex(glob string) string {
+	var builder strings.Builder
+
+	builder.WriteString("^")
+
+	for index := 0; index < len(glob); index++ {
+		switch char := glob[index]; {
+		case char == '*' && index+1 < len(glob) && glob[index+1] == '*':
+			builder.WriteString(".*")
+			index++
+		case char == '*':
+			builder.WriteString("[^/]*")
+		case char == '?':
+			builder.WriteString("[^/]")
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(char)))
+		}
+	}
+
+	builder.WriteString("$")
+
+	return builder.String()
+}
@@ -6,8 +6,8 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/packruler/rewrite-body/compressutil"
-	"github.com/packruler/rewrite-body/logger"
+	"github.com/packruler/traefik-themepark/compressutil"
+	"github.com/packruler/traefik-themepark/logger"
 )
 
 // RequestWrapper a struct that centralizes request modifications.
@@ -29,7 +29,7 @@ func WrapRequest(request *http.Request, monitoringConfig MonitoringConfig, logWr
 
 // CloneNoEncode create an http.Request that request no encoding.
 func (req *RequestWrapper) CloneNoEncode() *http.Request {
-	clonedRequest := req.Clone(req.Context())
+	clonedRequest := req.clone()
 
 	clonedRequest.Header.Set("Accept-Encoding", compressutil.Identity)
 
@@ -38,13 +38,24 @@ func (req *RequestWrapper) CloneNoEncode() *http.Request {
 
 // CloneWithSupportedEncoding create an http.Request that request only supported encoding.
 func (req *RequestWrapper) CloneWithSupportedEncoding() *http.Request {
-	clonedRequest := req.Clone(req.Context())
+	clonedRequest := req.clone()
 
 	clonedRequest.Header.Set("Accept-Encoding", removeUnsupportedAcceptEncoding(clonedRequest.Header))
 
 	return clonedRequest
 }
 
+// clone create an http.Request for the whole body. Ranges of the original body do not match the rewritten
+// body, so the whole body is requested and sent instead, which is allowed for any range request.
+func (req *RequestWrapper) clone() *http.Request {
+	clonedRequest := req.Clone(req.Context())
+
+	clonedRequest.Header.Del("Range")
+	clonedRequest.Header.Del("If-Range")
+
+	return clonedRequest
+}
+
 // GetEncodingTarget get the supported encoding algorithm preferred by request.
 func (req *RequestWrapper) GetEncodingTarget() string {
 	// Limit Accept-Encoding header to encodings we can handle.
@@ -53,8 +64,12 @@ func (req *RequestWrapper) GetEncodingTarget() string {
 	filteredEncodings := make([]encodingSpec, 0, len(acceptEncoding))
 
 	for _, a := range acceptEncoding {
-		switch a.Value {
-		case compressutil.Gzip, compressutil.Deflate:
+		// A quality of 0 means the client does not accept the encoding at all.
+		if a.Quality <= 0 {
+			continue
+		}
+
+		if a.Value != compressutil.Identity && a.Value != "" && compressutil.IsSupported(a.Value) {
 			filteredEncodings = append(filteredEncodings, a)
 		}
 	}
@@ -63,7 +78,8 @@ func (req *RequestWrapper) GetEncodingTarget() string {
 		return compressutil.Identity
 	}
 
-	sort.Slice(filteredEncodings, func(i, j int) bool {
+	// Keep the order of the header between encodings with the same quality.
+	sort.SliceStable(filteredEncodings, func(i, j int) bool {
 		return filteredEncodings[i].Quality > filteredEncodings[j].Quality
 	})
 
@@ -78,7 +94,11 @@ type encodingSpec struct {
 func parseAcceptEncoding(header http.Header) []encodingSpec {
 	encodingHeader := header.Get("Accept-Encoding")
 	if encodingHeader == "*" {
-		return []encodingSpec{{Quality: 1.0, Value: compressutil.Gzip}, {Quality: 1.0, Value: compressutil.Deflate}}
+		return []encodingSpec{
+			{Quality: 1.0, Value: compressutil.Gzip},
+			{Quality: 1.0, Value: compressutil.Deflate},
+			{Quality: 1.0, Value: compressutil.Brotli},
+		}
 	}
 
 	encodingList := strings.Split(encodingHeader, ",")
@@ -97,19 +117,24 @@ func parseEncodingItem(encoding string) encodingSpec {
 		return encodingSpec{Value: compressutil.Gzip, Quality: 1.0}
 	}
 
-	split := strings.Split(encoding, ";q=")
+	split := strings.Split(encoding, ";")
 	quality := 1.0
 
-	if qualitySplitSize := 2; len(split) == qualitySplitSize {
+	for _, parameter := range split[1:] {
+		parameter = strings.TrimSpace(parameter)
+		if !strings.HasPrefix(parameter, "q=") {
+			continue
+		}
+
 		targetFloat := 64
 
-		parsedQuality, err := strconv.ParseFloat(split[1], targetFloat)
+		parsedQuality, err := strconv.ParseFloat(strings.TrimPrefix(parameter, "q="), targetFloat)
 		if err == nil {
 			quality = parsedQuality
 		}
 	}
 
-	return encodingSpec{Value: split[0], Quality: quality}
+	return encodingSpec{Value: strings.ToLower(strings.TrimSpace(split[0])), Quality: quality}
 }
 
 func removeUnsupportedAcceptEncoding(header http.Header) string {
@@ -118,8 +143,7 @@ func removeUnsupportedAcceptEncoding(header http.Header) string {
 
 	for _, encoding := range encodingList {
 		split := strings.Split(strings.TrimSpace(encoding), ";q=")
-		switch split[0] {
-		case compressutil.Gzip, compressutil.Deflate, compressutil.Identity:
+		if split[0] != "" && compressutil.IsSupported(split[0]) {
 			result = append(result, encoding)
 		}
 	}
@@ -129,20 +153,16 @@ func removeUnsupportedAcceptEncoding(header http.Header) string {
 
 // SupportsProcessing determine if http.Request is supported by this plugin.
 func (req *RequestWrapper) SupportsProcessing() bool {
-	acceptHeader := req.Header.Get("Accept")
-	isSupported := false
-
-	for _, monitoredType := range req.monitoring.Types {
-		if strings.Contains(acceptHeader, monitoredType) {
-			isSupported = true
-		}
+	if !req.monitoring.SupportsPath(req.URL.Path) {
+		return false
 	}
 
-	if !isSupported {
+	acceptHeader := strings.Join(req.Header.Values("Accept"), ",")
+	if !acceptsAnyType(acceptHeader, req.monitoring.Types, req.monitoring.AcceptWildcard) {
 		return false
 	}
 
-	isSupported = false
+	isSupported := false
 
 	// Ignore non GET requests
 	for _, monitoredMethod := range req.monitoring.Methods {
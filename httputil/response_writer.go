@@ -0,0 +1,353 @@
+// Package httputil a package for handling http data tasks
+package httputil
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/packruler/traefik-themepark/compressutil"
+	"github.com/packruler/traefik-themepark/logger"
+)
+
+// ResponseWrapper a wrapper used to simplify ResponseWriter data access and manipulation.
+type ResponseWrapper struct {
+	buffer      *bytes.Buffer
+	wroteHeader bool
+	maxBodySize int64
+	passthrough bool
+	format      string
+
+	rewriteSettings
+
+	sourceEncoding string
+	reencoding     bool
+	headerPending  bool
+	encoding       string
+	notModified    bool
+	// output receives the body once it is passed through, encoding it when re-encoding.
+	output  io.Writer
+	encoder compressutil.Writer
+
+	code int `default:"200"`
+
+	logWriter logger.LogWriter
+
+	http.ResponseWriter
+}
+
+// WrapWriter create a ResponseWrapper for provided configuration.
+func WrapWriter(
+	responseWriter http.ResponseWriter,
+	monitoringConfig MonitoringConfig,
+	logWriter logger.LogWriter,
+	lastModified bool,
+) *ResponseWrapper {
+	return &ResponseWrapper{
+		buffer:          compressutil.AcquireBuffer(),
+		wroteHeader:     false,
+		rewriteSettings: rewriteSettings{lastModified: lastModified, monitoring: monitoringConfig},
+		code:            http.StatusOK,
+		logWriter:       logWriter,
+		ResponseWriter:  responseWriter,
+	}
+}
+
+// WriteHeader into wrapped ResponseWriter.
+func (wrapper *ResponseWrapper) WriteHeader(statusCode int) {
+	if wrapper.wroteHeader {
+		return
+	}
+
+	if isInformational(statusCode) {
+		// Informational responses precede the final response and are passed through.
+		wrapper.ResponseWriter.WriteHeader(statusCode)
+
+		return
+	}
+
+	wrapper.code = statusCode
+	wrapper.wroteHeader = true
+
+	header := wrapper.ResponseWriter.Header()
+	response := wrapper.prepareHeader(header, statusCode)
+
+	if response.notModified {
+		wrapper.notModified = true
+		wrapper.code = http.StatusNotModified
+
+		wrapper.ResponseWriter.WriteHeader(http.StatusNotModified)
+
+		return
+	}
+
+	wrapper.sourceEncoding = wrapper.getContentEncoding()
+	wrapper.reencoding = response.reencoding
+
+	// Delegates the Content-Length Header creation to the final body write.
+	header.Del("Content-Length")
+
+	if wrapper.reencoding {
+		// The Content-Encoding of a re-encoded body depends on its size so the header is sent along with the body.
+		wrapper.headerPending = true
+
+		return
+	}
+
+	wrapper.ResponseWriter.WriteHeader(statusCode)
+}
+
+// sendHeader send the header held back for a re-encoded body of size bytes.
+func (wrapper *ResponseWrapper) sendHeader(size int64) {
+	if !wrapper.headerPending {
+		return
+	}
+
+	wrapper.headerPending = false
+	wrapper.encoding = wrapper.setReencoding(wrapper.ResponseWriter.Header(), size)
+
+	wrapper.ResponseWriter.WriteHeader(wrapper.code)
+}
+
+// Write data to internal buffer and mark the status code as http.StatusOK.
+// Once the body exceeds the maximum size the buffer and any further data are written unchanged.
+func (wrapper *ResponseWrapper) Write(data []byte) (int, error) {
+	if !wrapper.wroteHeader {
+		wrapper.WriteHeader(http.StatusOK)
+	}
+
+	if wrapper.notModified {
+		return len(data), nil
+	}
+
+	if wrapper.passthrough {
+		return wrapper.output.Write(data)
+	}
+
+	if wrapper.maxBodySize > 0 && int64(wrapper.buffer.Len()+len(data)) > wrapper.maxBodySize {
+		wrapper.logWriter.LogWarningf(
+			"Response body exceeds maxBodySize of %d bytes, passing it through unchanged",
+			wrapper.maxBodySize,
+		)
+
+		if err := wrapper.startPassthrough(int64(wrapper.buffer.Len() + len(data))); err != nil {
+			return 0, err
+		}
+
+		return wrapper.output.Write(data)
+	}
+
+	return wrapper.buffer.Write(data)
+}
+
+// startPassthrough write the buffered data and send any further data directly to the client.
+// The body is known to be at least size bytes.
+func (wrapper *ResponseWrapper) startPassthrough(size int64) error {
+	wrapper.passthrough = true
+	wrapper.output = wrapper.ResponseWriter
+
+	if wrapper.reencoding {
+		wrapper.sendHeader(size)
+
+		encoder, err := compressutil.NewWriter(wrapper.ResponseWriter, wrapper.encoding, wrapper.compressionLevel)
+		if err != nil {
+			return err
+		}
+
+		wrapper.encoder = encoder
+		wrapper.output = encoder
+	}
+
+	if _, err := wrapper.output.Write(wrapper.buffer.Bytes()); err != nil {
+		return err
+	}
+
+	wrapper.buffer.Reset()
+
+	return nil
+}
+
+// Close finish writing a body that was passed through.
+func (wrapper *ResponseWrapper) Close() error {
+	if wrapper.encoder == nil {
+		return nil
+	}
+
+	return wrapper.encoder.Close()
+}
+
+// IsNotModified check if the client already had the rewritten body and was sent a not modified response.
+func (wrapper *ResponseWrapper) IsNotModified() bool {
+	return wrapper.notModified
+}
+
+// IsPassthrough check if the body exceeded the maximum size and was written unchanged.
+func (wrapper *ResponseWrapper) IsPassthrough() bool {
+	return wrapper.passthrough
+}
+
+// GetBuffer get a pointer to the ResponseWriter buffer.
+func (wrapper *ResponseWrapper) GetBuffer() *bytes.Buffer {
+	return wrapper.buffer
+}
+
+// Release return the buffer to the pool once the response is complete. The wrapper must not be used afterwards.
+func (wrapper *ResponseWrapper) Release() {
+	compressutil.ReleaseBuffer(wrapper.buffer)
+	wrapper.buffer = nil
+}
+
+// GetContent load the content currently in the internal buffer
+// accounting for possible encoding.
+func (wrapper *ResponseWrapper) GetContent() ([]byte, error) {
+	wrapper.format = compressutil.GetFormat(wrapper.buffer.Bytes(), wrapper.sourceEncoding)
+
+	return compressutil.Decode(wrapper.GetBuffer(), wrapper.format)
+}
+
+// GetContentFormat get the format rewritten content is encoded with. This matches the content loaded
+// by GetContent unless the response is re-encoded with the encoding target.
+func (wrapper *ResponseWrapper) GetContentFormat() string {
+	if wrapper.reencoding {
+		return wrapper.encodingTarget
+	}
+
+	if wrapper.format == "" {
+		return wrapper.sourceEncoding
+	}
+
+	return wrapper.format
+}
+
+// IsReencoding check if the response is re-encoded with the encoding target.
+func (wrapper *ResponseWrapper) IsReencoding() bool {
+	return wrapper.reencoding
+}
+
+// SetContent write data to the internal ResponseWriter buffer
+// and match initial encoding. Re-encoded bodies use the encoding their header is sent with instead.
+func (wrapper *ResponseWrapper) SetContent(data []byte, encoding string) {
+	if !wrapper.wroteHeader {
+		wrapper.WriteHeader(http.StatusOK)
+	}
+
+	if wrapper.headerPending {
+		wrapper.sendHeader(int64(len(data)))
+		encoding = wrapper.encoding
+	}
+
+	// Encoding straight to the client avoids holding the whole encoded body in memory.
+	encoder, err := compressutil.NewWriter(wrapper.ResponseWriter, encoding, wrapper.compressionLevel)
+	if err != nil {
+		wrapper.logWriter.LogErrorf("unable to create encoder: %v", err)
+
+		return
+	}
+
+	if _, err := encoder.Write(data); err != nil {
+		wrapper.logWriter.LogErrorf("unable to write rewriten body: %v", err)
+		wrapper.LogHeaders()
+	}
+
+	if err := encoder.Close(); err != nil {
+		wrapper.logWriter.LogErrorf("unable to close encoder: %v", err)
+	}
+}
+
+func (wrapper *ResponseWrapper) getHeader(headerName string) string {
+	return wrapper.ResponseWriter.Header().Get(headerName)
+}
+
+// LogHeaders writes current response headers.
+func (wrapper *ResponseWrapper) LogHeaders() {
+	wrapper.logWriter.LogDebugf("Error Headers: %v", wrapper.ResponseWriter.Header())
+}
+
+// getContentEncoding get the Content-Encoding header value.
+func (wrapper *ResponseWrapper) getContentEncoding() string {
+	return wrapper.getHeader("Content-Encoding")
+}
+
+// SupportsProcessing determine if HttpWrapper is supported by this plugin based on encoding.
+func (wrapper *ResponseWrapper) SupportsProcessing() bool {
+	return supportsRewriting(wrapper.code, wrapper.ResponseWriter.Header(), wrapper.monitoring)
+}
+
+// supportsRewriting determine if a response with statusCode and header is rewritten by this plugin.
+func supportsRewriting(statusCode int, header http.Header, monitoring MonitoringConfig) bool {
+	return monitoring.SupportsStatusCode(statusCode) && SupportsResponse(header, monitoring)
+}
+
+// SupportsResponse determine if a response with header is supported by this plugin.
+func SupportsResponse(header http.Header, monitoring MonitoringConfig) bool {
+	foundContentType := false
+
+	// If content type does not match return values with false
+	contentType := header.Get("Content-Type")
+	for _, monitoredType := range monitoring.Types {
+		if strings.Contains(contentType, monitoredType) {
+			foundContentType = true
+
+			break
+		}
+	}
+
+	if !foundContentType {
+		return false
+	}
+
+	encoding := header.Get("Content-Encoding")
+
+	// If content type is supported validate encoding as well
+	return compressutil.IsSupported(encoding)
+}
+
+// SetMaxBodySize update the size in bytes above which bodies are no longer buffered, or 0 for no limit.
+func (wrapper *ResponseWrapper) SetMaxBodySize(value int64) {
+	wrapper.maxBodySize = value
+}
+
+// CloseNotify returns a channel that receives at most a
+// single value (true) when the client connection has gone away.
+func (wrapper *ResponseWrapper) CloseNotify() <-chan bool {
+	if w, ok := wrapper.ResponseWriter.(http.CloseNotifier); ok {
+		return w.CloseNotify()
+	}
+
+	return make(<-chan bool)
+}
+
+// Hijack hijacks the connection.
+func (wrapper *ResponseWrapper) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := wrapper.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+
+	return nil, nil, fmt.Errorf("%T is not a http.Hijacker", wrapper.ResponseWriter)
+}
+
+// Flush sends any buffered data to the client.
+func (wrapper *ResponseWrapper) Flush() {
+	// If WriteHeader was already called from the caller, this is a NOOP.
+	// Otherwise, codeCatcher.code is actually a 200 here.
+	wrapper.WriteHeader(wrapper.code)
+
+	if wrapper.headerPending {
+		// Nothing can be sent before the size of a re-encoded body is known.
+		return
+	}
+
+	if wrapper.encoder != nil {
+		if err := wrapper.encoder.Flush(); err != nil {
+			wrapper.logWriter.LogErrorf("unable to flush encoder: %v", err)
+		}
+	}
+
+	if flusher, ok := wrapper.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
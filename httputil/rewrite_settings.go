@@ -0,0 +1,85 @@
+package httputil
+
+import "net/http"
+
+// rewriteSettings the settings shared by the buffered and streamed response wrappers.
+type rewriteSettings struct {
+	lastModified     bool
+	encodingTarget   string
+	compressionLevel int
+	minSize          int64
+	etagVariant      func() string
+	ifNoneMatch      string
+	monitoring       MonitoringConfig
+}
+
+// finalResponse how a final response is handled, as decided by prepareHeader.
+type finalResponse struct {
+	// notModified the client already has the rewritten body so it is neither rewritten nor sent.
+	notModified bool
+	rewriting   bool
+	reencoding  bool
+}
+
+// SetLastModified update the local lastModified variable from non-package-based users.
+func (settings *rewriteSettings) SetLastModified(value bool) {
+	settings.lastModified = value
+}
+
+// SetEncodingTarget request rewritten bodies the service sent uncompressed to be encoded with target.
+func (settings *rewriteSettings) SetEncodingTarget(target string) {
+	settings.encodingTarget = target
+}
+
+// SetETagVariant derive the ETag of rewritten responses from the service's ETag and the variant returned by
+// getVariant, and answer with a not modified response when it matches ifNoneMatch, the If-None-Match header
+// sent by the client. getVariant is only called once an ETag is derived.
+func (settings *rewriteSettings) SetETagVariant(getVariant func() string, ifNoneMatch string) {
+	settings.etagVariant = getVariant
+	settings.ifNoneMatch = ifNoneMatch
+}
+
+// SetCompressionLevel update the level bodies are compressed with, or compressutil.DefaultLevel.
+func (settings *rewriteSettings) SetCompressionLevel(level int) {
+	settings.compressionLevel = level
+}
+
+// SetMinSize update the size in bytes below which re-encoded bodies are sent uncompressed.
+// Streamed bodies are only sent uncompressed when the service announces their Content-Length.
+func (settings *rewriteSettings) SetMinSize(value int64) {
+	settings.minSize = value
+}
+
+// prepareHeader update the header of a final response with statusCode and decide how it is handled.
+// A not modified response is expected to be sent instead when the client already has the rewritten body.
+func (settings *rewriteSettings) prepareHeader(header http.Header, statusCode int) finalResponse {
+	if !settings.lastModified {
+		header.Del("Last-Modified")
+	}
+
+	if deriveETag(header, statusCode, settings.etagVariant, settings.ifNoneMatch, settings.monitoring) {
+		header.Del("Content-Length")
+
+		return finalResponse{notModified: true}
+	}
+
+	response := finalResponse{
+		rewriting:  supportsRewriting(statusCode, header, settings.monitoring),
+		reencoding: canReencode(header, statusCode, settings.encodingTarget),
+	}
+
+	if response.rewriting {
+		// Ranges are not served for rewritten bodies.
+		header.Del("Accept-Ranges")
+	}
+
+	return response
+}
+
+// setReencoding update header for a re-encoded body of size bytes, or -1 when unknown, and get its encoding.
+func (settings *rewriteSettings) setReencoding(header http.Header, size int64) string {
+	encoding := getReencoding(settings.encodingTarget, size, settings.minSize)
+	setEncodingHeaders(header, encoding)
+
+	return encoding
+}
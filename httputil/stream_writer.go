@@ -0,0 +1,409 @@
+package httputil
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/packruler/traefik-themepark/compressutil"
+	"github.com/packruler/traefik-themepark/logger"
+)
+
+// streamBufferSize size of the decoded chunks passed to the StreamRewriter.
+const streamBufferSize = 32 * 1024
+
+var errStreamClosed = errors.New("stream rewriting stopped")
+
+// ErrStreamAborted is returned by StreamWrapper.Close when the body could not be rewritten to its end.
+var ErrStreamAborted = errors.New("streamed body could not be rewritten to its end")
+
+// StreamRewriter rewrites a decoded response body while it is streamed.
+type StreamRewriter interface {
+	// Write get the rewritten content that can be sent for chunk. Content may be held back,
+	// for example when a match could continue in the next chunk, and returned by a later call.
+	Write(chunk []byte) []byte
+	// Close get the content still held back once the body is complete.
+	Close() []byte
+}
+
+// streamMessage either data written by the service or a request to flush the data written so far.
+type streamMessage struct {
+	data    []byte
+	flushed chan struct{}
+}
+
+// StreamWrapper a ResponseWriter that decodes, rewrites and encodes supported responses on the fly.
+// Rewriting runs in its own goroutine reading the written data through the decoder.
+type StreamWrapper struct {
+	rewriter    StreamRewriter
+	wroteHeader bool
+	processing  bool
+	closed      bool
+	aborted     bool
+	encoding    string
+	format      string
+
+	rewriteSettings
+
+	reencoding  bool
+	notModified bool
+
+	messages chan streamMessage
+	done     chan struct{}
+	encoder  compressutil.Writer
+
+	logWriter logger.LogWriter
+
+	http.ResponseWriter
+}
+
+// WrapStreamWriter create a StreamWrapper rewriting supported responses with rewriter.
+func WrapStreamWriter(
+	responseWriter http.ResponseWriter,
+	rewriter StreamRewriter,
+	monitoringConfig MonitoringConfig,
+	logWriter logger.LogWriter,
+	lastModified bool,
+) *StreamWrapper {
+	return &StreamWrapper{
+		rewriter:        rewriter,
+		rewriteSettings: rewriteSettings{lastModified: lastModified, monitoring: monitoringConfig},
+		messages:        make(chan streamMessage),
+		done:            make(chan struct{}),
+		logWriter:       logWriter,
+		ResponseWriter:  responseWriter,
+	}
+}
+
+// WriteHeader into wrapped ResponseWriter and start rewriting if the response is supported.
+func (wrapper *StreamWrapper) WriteHeader(statusCode int) {
+	if wrapper.wroteHeader {
+		return
+	}
+
+	if isInformational(statusCode) {
+		// Informational responses precede the final response and are passed through.
+		wrapper.ResponseWriter.WriteHeader(statusCode)
+
+		return
+	}
+
+	wrapper.wroteHeader = true
+	header := wrapper.ResponseWriter.Header()
+
+	response := wrapper.prepareHeader(header, statusCode)
+
+	if response.notModified {
+		wrapper.notModified = true
+
+		wrapper.ResponseWriter.WriteHeader(http.StatusNotModified)
+
+		return
+	}
+
+	wrapper.processing = response.rewriting
+
+	if wrapper.processing {
+		wrapper.encoding = header.Get("Content-Encoding")
+		wrapper.reencoding = response.reencoding
+
+		if wrapper.reencoding {
+			wrapper.encodingTarget = wrapper.setReencoding(header, getAnnouncedSize(header))
+		}
+
+		// The length of the rewritten body is unknown until it has been sent.
+		header.Del("Content-Length")
+
+		go wrapper.run()
+	} else {
+		wrapper.logWriter.LogDebugf("Ignoring unsupported response: %v", header)
+
+		if response.reencoding {
+			wrapper.startEncoding(header)
+		}
+	}
+
+	wrapper.ResponseWriter.WriteHeader(statusCode)
+}
+
+// startEncoding encode the body of a response that is not rewritten for the client with the encoding target,
+// unless it is announced smaller than the minimum size.
+func (wrapper *StreamWrapper) startEncoding(header http.Header) {
+	encoding := wrapper.setReencoding(header, getAnnouncedSize(header))
+	if encoding == compressutil.Identity {
+		return
+	}
+
+	encoder, err := compressutil.NewWriter(wrapper.ResponseWriter, encoding, wrapper.compressionLevel)
+	if err != nil {
+		wrapper.logWriter.LogErrorf("unable to create encoder: %v", err)
+
+		return
+	}
+
+	wrapper.encoder = encoder
+
+	header.Del("Content-Length")
+}
+
+// getAnnouncedSize get the size of a body announced by the service, or -1 when unknown. Only this size is
+// known before the body is streamed.
+func getAnnouncedSize(header http.Header) int64 {
+	size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return -1
+	}
+
+	return size
+}
+
+// Write data to the rewriting goroutine or directly to the wrapped ResponseWriter for unsupported responses.
+func (wrapper *StreamWrapper) Write(data []byte) (int, error) {
+	if !wrapper.wroteHeader {
+		wrapper.WriteHeader(http.StatusOK)
+	}
+
+	if wrapper.notModified {
+		return len(data), nil
+	}
+
+	if !wrapper.processing {
+		if wrapper.encoder != nil {
+			return wrapper.encoder.Write(data)
+		}
+
+		return wrapper.ResponseWriter.Write(data)
+	}
+
+	// The reader keeps the data after Write returns so the caller's slice can not be used. Messages are not built
+	// in the send case since Yaegi sends them empty.
+	message := streamMessage{data: append([]byte{}, data...)}
+
+	select {
+	case wrapper.messages <- message:
+		return len(data), nil
+	case <-wrapper.done:
+		return 0, errStreamClosed
+	}
+}
+
+// Flush sends the data rewritten so far to the client.
+func (wrapper *StreamWrapper) Flush() {
+	if !wrapper.wroteHeader {
+		wrapper.WriteHeader(http.StatusOK)
+	}
+
+	if !wrapper.processing {
+		wrapper.flush()
+
+		return
+	}
+
+	flushed := make(chan struct{})
+	message := streamMessage{flushed: flushed}
+
+	select {
+	case wrapper.messages <- message:
+		select {
+		case <-flushed:
+		case <-wrapper.done:
+		}
+	case <-wrapper.done:
+	}
+}
+
+// Close finish rewriting once the service has written the whole response. ErrStreamAborted is returned when
+// the body could not be rewritten to its end, the response should then be aborted since it is truncated.
+func (wrapper *StreamWrapper) Close() error {
+	if wrapper.closed {
+		return nil
+	}
+
+	wrapper.closed = true
+
+	if !wrapper.processing {
+		wrapper.closeEncoder()
+
+		return nil
+	}
+
+	close(wrapper.messages)
+	<-wrapper.done
+
+	if wrapper.aborted {
+		return ErrStreamAborted
+	}
+
+	return nil
+}
+
+// run decode the written data, rewrite it and encode it to the wrapped ResponseWriter.
+func (wrapper *StreamWrapper) run() {
+	defer close(wrapper.done)
+
+	source := bufio.NewReader(&streamReader{wrapper: wrapper})
+
+	header, _ := source.Peek(2)
+	if len(header) == 0 {
+		// There is no format to detect nor anything to rewrite in an empty body.
+		return
+	}
+
+	wrapper.format = compressutil.GetFormat(header, wrapper.encoding)
+
+	decoder, err := compressutil.NewReader(source, wrapper.format)
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			wrapper.logWriter.LogErrorf("Error loading content: %v", err)
+			wrapper.abort()
+		}
+
+		return
+	}
+
+	defer compressutil.CloseReader(decoder)
+
+	buffer := make([]byte, streamBufferSize)
+	received := false
+
+	for {
+		count, err := decoder.Read(buffer)
+		if count > 0 {
+			received = true
+
+			wrapper.send(wrapper.rewriter.Write(buffer[:count]))
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			wrapper.logWriter.LogErrorf("Error loading content: %v", err)
+			wrapper.abort()
+
+			return
+		}
+	}
+
+	// Like buffered rewriting there is nothing to rewrite in an empty body.
+	if received {
+		wrapper.send(wrapper.rewriter.Close())
+	}
+
+	wrapper.closeEncoder()
+}
+
+// abort stop rewriting and have Close abort the response.
+func (wrapper *StreamWrapper) abort() {
+	wrapper.aborted = true
+
+	wrapper.closeEncoder()
+}
+
+// closeEncoder finish the encoded body, if any.
+func (wrapper *StreamWrapper) closeEncoder() {
+	if wrapper.encoder != nil {
+		if err := wrapper.encoder.Close(); err != nil {
+			wrapper.logWriter.LogErrorf("unable to close encoder: %v", err)
+		}
+	}
+}
+
+// send encode data to the wrapped ResponseWriter matching the initial encoding.
+func (wrapper *StreamWrapper) send(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	if wrapper.encoder == nil {
+		format := wrapper.format
+		if wrapper.reencoding {
+			format = wrapper.encodingTarget
+		}
+
+		encoder, err := compressutil.NewWriter(wrapper.ResponseWriter, format, wrapper.compressionLevel)
+		if err != nil {
+			wrapper.logWriter.LogErrorf("unable to create encoder: %v", err)
+
+			return
+		}
+
+		wrapper.encoder = encoder
+	}
+
+	if _, err := wrapper.encoder.Write(data); err != nil {
+		wrapper.logWriter.LogErrorf("unable to write rewriten body: %v", err)
+	}
+}
+
+// flush send everything encoded so far to the client.
+func (wrapper *StreamWrapper) flush() {
+	if wrapper.encoder != nil {
+		if err := wrapper.encoder.Flush(); err != nil {
+			wrapper.logWriter.LogErrorf("unable to flush encoder: %v", err)
+		}
+	}
+
+	wrapper.flushResponse()
+}
+
+func (wrapper *StreamWrapper) flushResponse() {
+	if flusher, ok := wrapper.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// CloseNotify returns a channel that receives at most a
+// single value (true) when the client connection has gone away.
+func (wrapper *StreamWrapper) CloseNotify() <-chan bool {
+	if w, ok := wrapper.ResponseWriter.(http.CloseNotifier); ok {
+		return w.CloseNotify()
+	}
+
+	return make(<-chan bool)
+}
+
+// Hijack hijacks the connection.
+func (wrapper *StreamWrapper) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := wrapper.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+
+	return nil, nil, fmt.Errorf("%T is not a http.Hijacker", wrapper.ResponseWriter)
+}
+
+// streamReader feeds the data written to a StreamWrapper to its decoder.
+type streamReader struct {
+	wrapper *StreamWrapper
+	current []byte
+}
+
+// Read the written data in order. Flush requests are handled here since the decoder only asks for more
+// data once everything written before has been decoded and rewritten.
+func (reader *streamReader) Read(data []byte) (int, error) {
+	for len(reader.current) == 0 {
+		message, ok := <-reader.wrapper.messages
+		if !ok {
+			return 0, io.EOF
+		}
+
+		if message.flushed != nil {
+			reader.wrapper.flush()
+			close(message.flushed)
+
+			continue
+		}
+
+		reader.current = message.data
+	}
+
+	count := copy(data, reader.current)
+	reader.current = reader.current[count:]
+
+	return count, nil
+}
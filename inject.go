@@ -0,0 +1,406 @@
+package traefik_themepark
+
+import "bytes"
+
+// rawTextElements hold text that is not parsed as markup until their closing tag.
+var rawTextElements = map[string]bool{
+	"iframe":   true,
+	"noembed":  true,
+	"noframes": true,
+	"script":   true,
+	"style":    true,
+	"textarea": true,
+	"title":    true,
+	"xmp":      true,
+}
+
+// otherTagName name given to tags the scanner does not act on, sparing an allocation for each of them.
+const otherTagName = "*"
+
+// knownTagNames the tags acted on while scanning a document.
+var knownTagNames = getKnownTagNames()
+
+func getKnownTagNames() map[string]string {
+	names := map[string]string{"head": "head", "body": "body", "html": "html", "link": "link"}
+
+	for name := range rawTextElements {
+		names[name] = name
+	}
+
+	return names
+}
+
+// htmlTag a start or end tag found while scanning a document.
+type htmlTag struct {
+	name    string
+	closing bool
+	start   int
+	end     int
+}
+
+// injectHTML insert content once before the real closing tag of element, 'head' or 'body', of an HTML document.
+// Without a closing head tag content goes before the body,
+// and without a closing body tag before the end of the document.
+func injectHTML(body []byte, element string, content []byte) []byte {
+	index := findInjectionIndex(body, element)
+
+	result := make([]byte, 0, len(body)+len(content))
+	result = append(result, body[:index]...)
+	result = append(result, content...)
+
+	return append(result, body[index:]...)
+}
+
+// findInjectionIndex get the position content should be inserted at to end up at the end of element.
+func findInjectionIndex(body []byte, element string) int {
+	headEnd, bodyStart, bodyEnd, htmlEnd := -1, -1, -1, -1
+
+	scanTags(body, func(tag htmlTag) {
+		switch {
+		case tag.name == "head" && tag.closing && headEnd < 0:
+			headEnd = tag.start
+		case tag.name == "body" && !tag.closing && bodyStart < 0:
+			bodyStart = tag.start
+		case tag.name == "body" && tag.closing:
+			// Browsers keep adding content to the body after a closing tag so only the last one is the real end.
+			bodyEnd = tag.start
+		case tag.name == "html" && tag.closing:
+			htmlEnd = tag.start
+		}
+	})
+
+	candidates := []int{bodyEnd, htmlEnd}
+	if element == "head" {
+		candidates = append([]int{headEnd, bodyStart}, candidates...)
+	}
+
+	for _, index := range candidates {
+		if index >= 0 {
+			return index
+		}
+	}
+
+	return len(body)
+}
+
+// streamWindowSize limits how much of a streamed page is held back while looking for the end of an element.
+const streamWindowSize = 64 * 1024
+
+// streamInjection content injected at the end of element, 'head' or 'body', of a streamed page.
+type streamInjection struct {
+	element string
+	// content is only called once the injection point is reached so it can depend on the page sent before.
+	content func() []byte
+}
+
+// streamInjector injects content into a page while it is streamed. Only the markup that may still contain
+// the end of an element is held back, so content is injected before the first closing tag found rather than the last.
+type streamInjector struct {
+	scanner    htmlScanner
+	injections []streamInjection
+	pending    []byte
+	// inspect is called with every part of the page before it is sent.
+	inspect func(segment []byte)
+}
+
+// Write get the part of the page, including chunk, that can be sent.
+func (injector *streamInjector) Write(chunk []byte) []byte {
+	injector.pending = append(injector.pending, chunk...)
+	result := make([]byte, 0, len(injector.pending))
+
+	for len(injector.injections) > 0 {
+		injection := injector.injections[0]
+		index := -1
+
+		scanned := injector.scanner.scan(injector.pending, func(tag htmlTag) bool {
+			if injection.matches(tag) {
+				index = tag.start
+
+				return false
+			}
+
+			return true
+		})
+
+		if index < 0 {
+			// Give up on markup that is never terminated rather than holding back the whole page.
+			if len(injector.pending)-scanned > streamWindowSize {
+				scanned = len(injector.pending)
+				injector.scanner.rawText = ""
+			}
+
+			return append(result, injector.send(scanned)...)
+		}
+
+		result = append(result, injector.send(index)...)
+		result = append(result, injection.content()...)
+		injector.injections = injector.injections[1:]
+	}
+
+	return append(result, injector.send(len(injector.pending))...)
+}
+
+// Close get the rest of the page with the remaining injections at the end.
+func (injector *streamInjector) Close() []byte {
+	result := injector.send(len(injector.pending))
+
+	for _, injection := range injector.injections {
+		result = append(result, injection.content()...)
+	}
+
+	injector.injections = nil
+
+	return result
+}
+
+// send remove the first length bytes of the pending page and return them.
+func (injector *streamInjector) send(length int) []byte {
+	segment := append([]byte{}, injector.pending[:length]...)
+	injector.pending = append(injector.pending[:0], injector.pending[length:]...)
+
+	if injector.inspect != nil && len(segment) > 0 {
+		injector.inspect(segment)
+	}
+
+	return segment
+}
+
+// matches check if the content of the injection can be inserted before tag. The content of the head goes before
+// its closing tag or the body, and any content before the closing body or html tag.
+func (injection *streamInjection) matches(tag htmlTag) bool {
+	switch tag.name {
+	case "head":
+		return tag.closing && injection.element == "head"
+	case "body":
+		return tag.closing || injection.element == "head"
+	case "html":
+		return tag.closing
+	default:
+		return false
+	}
+}
+
+// scanTags call visit for every tag of body in order. Comments, attribute values and the content of raw
+// text elements such as scripts are skipped so markup inside of them is not mistaken for tags.
+func scanTags(body []byte, visit func(tag htmlTag)) {
+	scanner := &htmlScanner{}
+
+	scanner.scan(body, func(tag htmlTag) bool {
+		visit(tag)
+
+		return true
+	})
+}
+
+// htmlScanner finds tags in a document that may be received in chunks.
+type htmlScanner struct {
+	// rawText is the raw text element the previously scanned chunk ended in.
+	rawText string
+}
+
+// scan call visit for every complete tag of chunk until visit returns false and get the number of bytes scanned.
+// Markup cut off at the end of chunk is not scanned so it can be scanned again along with the next chunk.
+func (scanner *htmlScanner) scan(chunk []byte, visit func(tag htmlTag) bool) int {
+	lower := toLowerASCII(chunk)
+	index := 0
+
+	if scanner.rawText != "" {
+		index = skipRawText(lower, 0, scanner.rawText)
+		if index == len(lower) {
+			return scanner.getRawTextEnd(lower)
+		}
+
+		scanner.rawText = ""
+	}
+
+	for index < len(lower) {
+		offset := bytes.IndexByte(lower[index:], '<')
+		if offset < 0 {
+			return len(lower)
+		}
+
+		tag, end := readTag(lower, index+offset)
+		if end < 0 {
+			return index + offset
+		}
+
+		index = end
+
+		if tag.name == "" {
+			continue
+		}
+
+		if !visit(tag) {
+			return tag.start
+		}
+
+		if !tag.closing && rawTextElements[tag.name] {
+			index = skipRawText(lower, index, tag.name)
+			if index == len(lower) {
+				scanner.rawText = tag.name
+
+				return maxInt(end, scanner.getRawTextEnd(lower))
+			}
+		}
+	}
+
+	return len(lower)
+}
+
+// getRawTextEnd get the number of bytes of raw text that can be scanned while keeping enough of the end
+// to find a closing tag split between chunks.
+func (scanner *htmlScanner) getRawTextEnd(lower []byte) int {
+	return maxInt(0, len(lower)-len(scanner.rawText)-len("</"))
+}
+
+// readTag read the markup starting at start and return the tag found, if any, with the position after it.
+// The position is negative when the markup is not terminated.
+func readTag(lower []byte, start int) (htmlTag, int) {
+	rest := lower[start+1:]
+
+	switch {
+	case bytes.HasPrefix(rest, []byte("!--")):
+		end := bytes.Index(rest[3:], []byte("-->"))
+		if end < 0 {
+			return htmlTag{}, -1
+		}
+
+		return htmlTag{}, start + 4 + end + 3
+	case len(rest) > 0 && (rest[0] == '!' || rest[0] == '?'):
+		// Doctypes, CDATA sections and processing instructions end at the first '>'.
+		end := bytes.IndexByte(rest, '>')
+		if end < 0 {
+			return htmlTag{}, -1
+		}
+
+		return htmlTag{}, start + 1 + end + 1
+	}
+
+	tag := htmlTag{start: start, closing: len(rest) > 0 && rest[0] == '/'}
+
+	nameStart := start + 1
+	if tag.closing {
+		nameStart++
+	}
+
+	nameEnd := nameStart
+	for nameEnd < len(lower) && isTagNameChar(lower[nameEnd]) {
+		nameEnd++
+	}
+
+	if nameEnd == len(lower) {
+		return htmlTag{}, -1
+	}
+
+	// A '<' not followed by a letter is plain text.
+	if nameEnd == nameStart || lower[nameStart] < 'a' || lower[nameStart] > 'z' {
+		return htmlTag{}, start + 1
+	}
+
+	tag.name = otherTagName
+	if name, ok := knownTagNames[string(lower[nameStart:nameEnd])]; ok {
+		tag.name = name
+	}
+
+	end := findTagEnd(lower, nameEnd)
+	if end < 0 {
+		return htmlTag{}, -1
+	}
+
+	tag.end = end
+
+	return tag, end
+}
+
+// findTagEnd get the position after the '>' closing a tag, ignoring any '>' inside quoted attribute values.
+func findTagEnd(lower []byte, index int) int {
+	var quote byte
+
+	afterEquals := false
+
+	for ; index < len(lower); index++ {
+		char := lower[index]
+
+		if quote != 0 {
+			if char == quote {
+				quote = 0
+			}
+
+			continue
+		}
+
+		switch char {
+		case '>':
+			return index + 1
+		case '=':
+			afterEquals = true
+
+			continue
+		case '"', '\'':
+			if afterEquals {
+				quote = char
+			}
+		case ' ', '\t', '\n', '\r', '\f':
+			continue
+		}
+
+		afterEquals = false
+	}
+
+	return -1
+}
+
+// skipRawText get the position of the tag closing the raw text element name, or the end of the document.
+func skipRawText(lower []byte, index int, name string) int {
+	closing := []byte("</" + name)
+
+	for index < len(lower) {
+		offset := bytes.Index(lower[index:], closing)
+		if offset < 0 {
+			break
+		}
+
+		index += offset
+
+		after := index + len(closing)
+		if after >= len(lower) || !isTagNameChar(lower[after]) {
+			return index
+		}
+
+		index = after
+	}
+
+	return len(lower)
+}
+
+func isTagNameChar(char byte) bool {
+	switch char {
+	case ' ', '\t', '\n', '\r', '\f', '/', '>':
+		return false
+	default:
+		return true
+	}
+}
+
+// toLowerASCII lowercase only ASCII letters so positions match the original document.
+func toLowerASCII(body []byte) []byte {
+	result := make([]byte, len(body))
+
+	for index, char := range body {
+		if 'A' <= char && char <= 'Z' {
+			char += 'a' - 'A'
+		}
+
+		result[index] = char
+	}
+
+	return result
+}
+
+func maxInt(first, second int) int {
+	if first > second {
+		return first
+	}
+
+	return second
+}
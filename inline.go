@@ -0,0 +1,297 @@
+package traefik_themepark
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/packruler/traefik-themepark/cssutil"
+)
+
+const (
+	defaultInlineTTL = time.Hour
+	// maxImportDepth limits how deep @import chains are followed.
+	maxImportDepth = 10
+	fetchTimeout   = 10 * time.Second
+	// minRetryDelay is doubled for every failed fetch of a stylesheet, up to maxRetryDelay.
+	minRetryDelay = 30 * time.Second
+	maxRetryDelay = 30 * time.Minute
+)
+
+// inlineFormat embeds a stylesheet marked as either the "base" theme or an "addon", so the picker can swap
+// the base theme, with an optional media attribute.
+const inlineFormat string = "<style data-themepark=\"%s\"%s>%s</style>"
+
+var importRegex = regexp.MustCompile(`(?i)@import\s+(?:url\(\s*)?["']?([^"')\s;]+)["']?\s*\)?\s*([^;]*);`)
+
+var errImportDepth = errors.New("maximum @import depth exceeded")
+
+// styleCache fetches stylesheets, including their @import chains, and keeps them in memory.
+// Expired stylesheets are refreshed in the background while the last good copy is served, and stylesheets
+// that can not be fetched are only fetched again once a delay growing with every failure has passed.
+type styleCache struct {
+	client  *http.Client
+	assets  *assetServer
+	ttl     time.Duration
+	mutex   sync.Mutex
+	entries map[string]styleCacheEntry
+}
+
+type styleCacheEntry struct {
+	css string
+	// loaded is set once the stylesheet has been fetched, css is then the last good copy.
+	loaded  bool
+	expires time.Time
+	// err is the error of the last fetch, which is not retried before retry.
+	err      error
+	failures int
+	retry    time.Time
+	// loading is closed once the fetch in progress is done, it is nil without one.
+	loading chan struct{}
+}
+
+func newStyleCache(ttl string, assets *assetServer) (*styleCache, error) {
+	duration := defaultInlineTTL
+
+	if ttl != "" {
+		var err error
+
+		duration, err = time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing inlineTtl %q: %w", ttl, err)
+		}
+	}
+
+	return &styleCache{
+		client:  &http.Client{Timeout: fetchTimeout},
+		assets:  assets,
+		ttl:     duration,
+		entries: make(map[string]styleCacheEntry),
+	}, nil
+}
+
+// getInlineString get every stylesheet of config embedded in a style tag.
+func (cache *styleCache) getInlineString(config *Config) (string, error) {
+	var stringBuilder strings.Builder
+
+	for _, sheet := range config.getStylesheets() {
+		css, err := cache.get(sheet.href, 0)
+		if err != nil {
+			return "", err
+		}
+
+		kind := "addon"
+		if sheet.base {
+			kind = "base"
+		}
+
+		// Prevent stylesheet content from closing the style tag early.
+		css = strings.ReplaceAll(css, "</style", "<\\/style")
+
+		stringBuilder.WriteString(fmt.Sprintf(inlineFormat, kind, sheet.getMediaAttribute(), css))
+	}
+
+	return stringBuilder.String(), nil
+}
+
+// get load the stylesheet at href with its imports resolved, using the cached version while valid.
+func (cache *styleCache) get(href string, depth int) (string, error) {
+	if depth > maxImportDepth {
+		return "", fmt.Errorf("%w: %s", errImportDepth, href)
+	}
+
+	entry, loading := cache.lookup(href)
+
+	switch {
+	case loading != nil && entry.loaded:
+		// The last good copy is served while the stylesheet is refreshed.
+		go func() { _, _ = cache.load(href, depth, loading) }()
+	case loading != nil:
+		return cache.load(href, depth, loading)
+	case entry.loading != nil && !entry.loaded && depth > 0:
+		// Imports do not wait for each other since @import chains may be circular.
+		return cache.load(href, depth, nil)
+	case entry.loading != nil && !entry.loaded:
+		// Wait for the fetch in progress, like the one warming the cache, rather than fetching it again.
+		<-entry.loading
+
+		cache.mutex.Lock()
+		entry = cache.entries[href]
+		cache.mutex.Unlock()
+	}
+
+	if !entry.loaded {
+		return "", entry.err
+	}
+
+	return entry.css, nil
+}
+
+// lookup get the entry of href and, when it has to be fetched, the channel the caller must load it with.
+func (cache *styleCache) lookup(href string) (styleCacheEntry, chan struct{}) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	entry := cache.entries[href]
+	now := time.Now()
+
+	if entry.loading != nil || (entry.loaded && now.Before(entry.expires)) || now.Before(entry.retry) {
+		return entry, nil
+	}
+
+	entry.loading = make(chan struct{})
+	cache.entries[href] = entry
+
+	return entry, entry.loading
+}
+
+// load fetch the stylesheet at href and update its entry, closing loading once done when it is set.
+// The last good copy is returned when the stylesheet can not be fetched.
+func (cache *styleCache) load(href string, depth int, loading chan struct{}) (string, error) {
+	css, err := cache.fetch(href)
+	if err == nil {
+		css, err = cache.resolveImports(href, css, depth)
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	entry := cache.entries[href]
+
+	if loading != nil {
+		entry.loading = nil
+
+		close(loading)
+	}
+
+	if err != nil {
+		entry.err = err
+		entry.failures++
+		entry.retry = time.Now().Add(getRetryDelay(entry.failures))
+		cache.entries[href] = entry
+
+		if entry.loaded {
+			return entry.css, nil
+		}
+
+		return "", err
+	}
+
+	cache.entries[href] = styleCacheEntry{
+		css:     css,
+		loaded:  true,
+		expires: time.Now().Add(cache.ttl),
+		loading: entry.loading,
+	}
+
+	return css, nil
+}
+
+// getRetryDelay get the delay before a stylesheet is fetched again once failures fetches in a row failed.
+func getRetryDelay(failures int) time.Duration {
+	delay := minRetryDelay
+
+	for attempt := 1; attempt < failures && delay < maxRetryDelay; attempt++ {
+		delay *= 2
+	}
+
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+
+	return delay
+}
+
+func (cache *styleCache) fetch(href string) (string, error) {
+	// Links point at the middleware itself when assets are served locally.
+	if cache.assets != nil && strings.HasPrefix(href, cache.assets.prefix+"/") {
+		body, err := cache.assets.load(strings.TrimPrefix(href, cache.assets.prefix))
+		if err != nil {
+			return "", fmt.Errorf("error loading %s: %w", href, err)
+		}
+
+		return string(body), nil
+	}
+
+	response, err := cache.client.Get(href)
+	if err != nil {
+		return "", fmt.Errorf("error fetching %s: %w", href, err)
+	}
+
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error fetching %s: unexpected status %d", href, response.StatusCode)
+	}
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s: %w", href, err)
+	}
+
+	return string(body), nil
+}
+
+// resolveImports make url() references absolute and replace @import rules with the imported stylesheets.
+func (cache *styleCache) resolveImports(href string, css string, depth int) (string, error) {
+	base, err := url.Parse(href)
+	if err != nil {
+		return "", fmt.Errorf("error parsing %s: %w", href, err)
+	}
+
+	css = cssutil.URLRegex.ReplaceAllStringFunc(css, func(match string) string {
+		reference := cssutil.URLRegex.FindStringSubmatch(match)[1]
+		if strings.HasPrefix(reference, "data:") || strings.HasPrefix(reference, "#") {
+			return match
+		}
+
+		resolved, err := base.Parse(strings.TrimSpace(reference))
+		if err != nil {
+			return match
+		}
+
+		return fmt.Sprintf("url(%q)", resolved.String())
+	})
+
+	var importErr error
+
+	css = importRegex.ReplaceAllStringFunc(css, func(match string) string {
+		if importErr != nil {
+			return match
+		}
+
+		groups := importRegex.FindStringSubmatch(match)
+
+		importHref, err := base.Parse(groups[1])
+		if err != nil {
+			importErr = fmt.Errorf("error parsing @import %s: %w", groups[1], err)
+
+			return match
+		}
+
+		imported, err := cache.get(importHref.String(), depth+1)
+		if err != nil {
+			importErr = err
+
+			return match
+		}
+
+		if media := strings.TrimSpace(groups[2]); media != "" {
+			return fmt.Sprintf("@media %s {\n%s\n}", media, imported)
+		}
+
+		return imported
+	})
+
+	if importErr != nil {
+		return "", importErr
+	}
+
+	return css, nil
+}
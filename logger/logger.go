@@ -62,7 +62,7 @@ func createLoggerWithBuffer(level LogLevel, buffer *bytes.Buffer) *LogWriter {
 }
 
 func (logger *LogWriter) writeLog(level LogLevel, message string) {
-	if level < logger.level {
+	if !logger.isEnabled(level) {
 		return
 	}
 
@@ -70,6 +70,11 @@ func (logger *LogWriter) writeLog(level LogLevel, message string) {
 	output.Print(message)
 }
 
+// isEnabled check if logs of level are written, so messages are only formatted when needed.
+func (logger *LogWriter) isEnabled(level LogLevel) bool {
+	return level >= logger.level
+}
+
 // LogTrace write Trace level logs.
 func (logger *LogWriter) LogTrace(message string) {
 	logger.writeLog(Trace, message)
@@ -97,25 +102,35 @@ func (logger *LogWriter) LogError(message string) {
 
 // LogTracef write Trace level logs with formatting similar to fmt.Sprintf.
 func (logger *LogWriter) LogTracef(format string, a ...interface{}) {
-	logger.writeLog(Trace, fmt.Sprintf(format, a...))
+	if logger.isEnabled(Trace) {
+		logger.writeLog(Trace, fmt.Sprintf(format, a...))
+	}
 }
 
 // LogDebugf write Debug level logs with formatting similar to fmt.Sprintf.
 func (logger *LogWriter) LogDebugf(format string, a ...interface{}) {
-	logger.writeLog(Debug, fmt.Sprintf(format, a...))
+	if logger.isEnabled(Debug) {
+		logger.writeLog(Debug, fmt.Sprintf(format, a...))
+	}
 }
 
 // LogInfof write Info level logs with formatting similar to fmt.Sprintf.
 func (logger *LogWriter) LogInfof(format string, a ...interface{}) {
-	logger.writeLog(Info, fmt.Sprintf(format, a...))
+	if logger.isEnabled(Info) {
+		logger.writeLog(Info, fmt.Sprintf(format, a...))
+	}
 }
 
 // LogWarningf write Warning level logs with formatting similar to fmt.Sprintf.
 func (logger *LogWriter) LogWarningf(format string, a ...interface{}) {
-	logger.writeLog(Warning, fmt.Sprintf(format, a...))
+	if logger.isEnabled(Warning) {
+		logger.writeLog(Warning, fmt.Sprintf(format, a...))
+	}
 }
 
 // LogErrorf write Error level logs with formatting similar to fmt.Sprintf.
 func (logger *LogWriter) LogErrorf(format string, a ...interface{}) {
-	logger.writeLog(Error, fmt.Sprintf(format, a...))
+	if logger.isEnabled(Error) {
+		logger.writeLog(Error, fmt.Sprintf(format, a...))
+	}
 }
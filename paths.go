@@ -0,0 +1,75 @@
+package traefik_themepark
+
+import (
+	"fmt"
+	"sort"
+)
+
+// StylesheetPaths lists the paths, relative to baseUrl, of every stylesheet the configuration may inject.
+// This includes the stylesheets of every app rule, detected app, and theme or addon allowed by the switcher.
+func (config *Config) StylesheetPaths() []string {
+	paths := make(map[string]bool)
+
+	for _, appConfig := range config.expandApps() {
+		appConfig.setDefaults()
+
+		themes := []string{appConfig.Theme, appConfig.LightTheme, appConfig.DarkTheme}
+		addons := appConfig.Addons
+
+		if appConfig.Switcher.Enabled {
+			themes = append(themes, appConfig.Switcher.Themes...)
+			addons = append(append([]string{}, addons...), appConfig.Switcher.Addons...)
+		}
+
+		for _, theme := range themes {
+			if theme := appConfig.getThemeOrDefault(theme); theme != "" {
+				paths[fmt.Sprintf(baseHrefFormat, "", appConfig.App, theme)] = true
+			}
+		}
+
+		for _, addon := range addons {
+			addonName := appConfig.getAddonName(addon)
+			paths[fmt.Sprintf(addonHrefFormat, "", appConfig.App, addonName, addonName)] = true
+		}
+	}
+
+	result := make([]string, 0, len(paths))
+	for stylesheetPath := range paths {
+		result = append(result, stylesheetPath)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// expandApps get a copy of the config for every app it may theme.
+func (config *Config) expandApps() []*Config {
+	configs := make([]*Config, 0, len(config.Apps)+1)
+
+	for index := range config.Apps {
+		if ruleConfig, err := config.forRule(&config.Apps[index]); err == nil {
+			configs = append(configs, ruleConfig)
+		}
+	}
+
+	if config.App != "" {
+		configs = append(configs, config.clone())
+	}
+
+	result := make([]*Config, 0, len(configs))
+
+	for _, appConfig := range configs {
+		if appConfig.App != autoApp {
+			result = append(result, appConfig)
+
+			continue
+		}
+
+		for _, app := range catalogApps {
+			result = append(result, appConfig.forDetectedApp(app))
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,146 @@
+package traefik_themepark
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+)
+
+const pickerPath = "/picker.js"
+
+const pickerFormat string = "<script " +
+	"src=\"%s\" " +
+	"data-themepark-picker " +
+	"data-app=\"%s\" " +
+	"data-base-url=\"%s\" " +
+	"data-cookie=\"%s\" " +
+	"data-theme=\"%s\" " +
+	"data-themes=\"%s\" " +
+	"defer></script>"
+
+// pickerScript renders a floating theme picker based on the data attributes of the injected script tag.
+// The selection is persisted in localStorage and the switcher cookie and applied without reloading the page.
+const pickerScript string = `(function () {
+  "use strict";
+
+  var script = document.querySelector("script[data-themepark-picker]");
+  if (!script || document.getElementById("themepark-picker")) {
+    return;
+  }
+
+  var app = script.getAttribute("data-app");
+  var baseURL = script.getAttribute("data-base-url");
+  var cookieName = script.getAttribute("data-cookie");
+  var themes = script.getAttribute("data-themes").split(",");
+  var current = script.getAttribute("data-theme");
+  var storageKey = "themepark:" + cookieName;
+
+  function themeURL(theme) {
+    return baseURL + "/css/base/" + app + "/" + theme + ".css";
+  }
+
+  function applyTheme(theme) {
+    var links = document.querySelectorAll("link[rel=stylesheet][href*='/css/base/']");
+    var styles = document.querySelectorAll("style[data-themepark=base]");
+    var link = links[0];
+
+    for (var index = 1; index < links.length; index++) {
+      links[index].parentNode.removeChild(links[index]);
+    }
+
+    if (!link) {
+      link = document.createElement("link");
+      link.rel = "stylesheet";
+      link.type = "text/css";
+
+      // Inlined themes are replaced in place so the addons following them keep precedence.
+      if (styles.length > 0) {
+        styles[0].parentNode.insertBefore(link, styles[0]);
+      } else {
+        document.head.appendChild(link);
+      }
+    }
+
+    for (var styleIndex = 0; styleIndex < styles.length; styleIndex++) {
+      styles[styleIndex].parentNode.removeChild(styles[styleIndex]);
+    }
+
+    link.removeAttribute("media");
+    link.href = themeURL(theme);
+    current = theme;
+  }
+
+  function saveTheme(theme) {
+    document.cookie = cookieName + "=" + encodeURIComponent(theme) +
+      "; path=/; max-age=31536000; samesite=lax" +
+      (location.protocol === "https:" ? "; secure" : "");
+
+    try {
+      localStorage.setItem(storageKey, theme);
+    } catch (ignored) {
+      // Storage may be unavailable in private browsing; the cookie is enough.
+    }
+  }
+
+  var stored = null;
+  try {
+    stored = localStorage.getItem(storageKey);
+  } catch (ignored) {
+    // Storage may be unavailable in private browsing; the cookie is enough.
+  }
+
+  if (stored && stored !== current && themes.indexOf(stored) !== -1) {
+    saveTheme(stored);
+    applyTheme(stored);
+  }
+
+  var container = document.createElement("div");
+  container.id = "themepark-picker";
+  container.style.cssText = "position:fixed;right:12px;bottom:12px;z-index:2147483647;" +
+    "font:12px sans-serif;opacity:0.6;";
+  container.onmouseenter = function () { container.style.opacity = "1"; };
+  container.onmouseleave = function () { container.style.opacity = "0.6"; };
+
+  var select = document.createElement("select");
+  select.title = "theme.park";
+  select.setAttribute("aria-label", "theme.park theme");
+  select.style.cssText = "padding:4px;border-radius:4px;";
+
+  for (var index = 0; index < themes.length; index++) {
+    var option = document.createElement("option");
+    option.value = themes[index];
+    option.textContent = themes[index];
+    option.selected = themes[index] === current;
+    select.appendChild(option);
+  }
+
+  select.onchange = function () {
+    saveTheme(select.value);
+    applyTheme(select.value);
+  };
+
+  container.appendChild(select);
+  document.body.appendChild(container);
+})();
+`
+
+// getPickerTag build the script tag loading the theme picker for the selected configuration.
+func (switcher *Switcher) getPickerTag(config *Config, selected *Config) string {
+	return fmt.Sprintf(
+		pickerFormat,
+		html.EscapeString(config.ReservedPath+pickerPath),
+		html.EscapeString(config.App),
+		html.EscapeString(config.BaseURL),
+		html.EscapeString(switcher.CookieName),
+		html.EscapeString(selected.Theme),
+		html.EscapeString(strings.Join(switcher.Themes, ",")),
+	)
+}
+
+func servePicker(response http.ResponseWriter) {
+	response.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	response.Header().Set("Cache-Control", "public, max-age=86400")
+
+	_, _ = response.Write([]byte(pickerScript))
+}
@@ -0,0 +1,221 @@
+package traefik_themepark
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	switcherSetPath   = "/set"
+	defaultCookieName = "themepark_theme"
+	addonsCookieName  = "_addons"
+	// cookieMaxAge keeps the selected theme for a year.
+	cookieMaxAge = 365 * 24 * 60 * 60
+)
+
+// Switcher holds the configuration for per-user theme selection.
+type Switcher struct {
+	Enabled    bool     `json:"enabled,omitempty"`
+	CookieName string   `json:"cookieName,omitempty"`
+	Themes     []string `json:"themes,omitempty"`
+	Addons     []string `json:"addons,omitempty"`
+	// Picker injects a floating widget letting users change their theme without leaving the page.
+	Picker bool `json:"picker,omitempty"`
+}
+
+func (switcher *Switcher) setDefaults(config *Config) {
+	if switcher.Picker {
+		switcher.Enabled = true
+	}
+
+	if !switcher.Enabled {
+		return
+	}
+
+	if switcher.CookieName == "" {
+		switcher.CookieName = defaultCookieName
+	}
+
+	// The lists are copied before being completed so the slices of the caller's config are left unchanged.
+	switcher.Themes = append([]string{}, switcher.Themes...)
+	if len(switcher.Themes) == 0 {
+		switcher.Themes = append(switcher.Themes, catalogThemes...)
+	}
+
+	for _, theme := range []string{config.Theme, config.App + "-base"} {
+		if !containsString(switcher.Themes, theme) {
+			switcher.Themes = append(switcher.Themes, theme)
+		}
+	}
+
+	if len(switcher.Addons) == 0 {
+		switcher.Addons = append([]string{}, catalogAddons[config.App]...)
+
+		if config.App == autoApp {
+			switcher.Addons = getAddonSuffixes()
+		}
+	}
+
+	addons := make([]string, 0, len(switcher.Addons))
+	for _, addon := range switcher.Addons {
+		addons = append(addons, config.getAddonName(addon))
+	}
+
+	switcher.Addons = addons
+}
+
+// getSelection get the configuration matching the theme and addons selected by the request's cookies.
+func (switcher *Switcher) getSelection(req *http.Request, config *Config) *Config {
+	if !switcher.Enabled {
+		return config
+	}
+
+	selected := *config
+
+	if cookie, err := req.Cookie(switcher.CookieName); err == nil {
+		if theme := switcher.normalizeTheme(cookie.Value, config); containsString(switcher.Themes, theme) {
+			selected.Theme = theme
+			// An explicit selection replaces following the browser's color scheme.
+			selected.LightTheme = ""
+			selected.DarkTheme = ""
+		}
+	}
+
+	if cookie, err := req.Cookie(switcher.CookieName + addonsCookieName); err == nil {
+		selected.Addons = make([]string, 0, len(switcher.Addons))
+
+		for _, addon := range splitList(cookie.Value) {
+			if addon = config.getAddonName(addon); containsString(switcher.Addons, addon) {
+				selected.Addons = append(selected.Addons, addon)
+			}
+		}
+	}
+
+	return &selected
+}
+
+// handleSet store the theme and addons requested in the query as cookies and redirect back.
+func (switcher *Switcher) handleSet(response http.ResponseWriter, req *http.Request, config *Config) {
+	query := req.URL.Query()
+
+	// The theme is stored as requested so 'base' applies to whichever app serves the next page.
+	_, hasTheme := query["theme"]
+	theme := strings.TrimSpace(query.Get("theme"))
+	if theme != "" && !containsString(switcher.Themes, switcher.normalizeTheme(theme, config)) {
+		http.Error(response, fmt.Sprintf("theme %q is not allowed", theme), http.StatusBadRequest)
+
+		return
+	}
+
+	addonValues, hasAddons := query["addons"]
+
+	addons, err := switcher.getAllowedAddons(addonValues, config)
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	// Cookies are only set once the whole selection is valid so a rejected request changes nothing.
+	// Parameters missing from the query leave their cookie untouched.
+	if hasTheme {
+		http.SetCookie(response, switcher.createCookie(req, switcher.CookieName, theme))
+	}
+
+	if hasAddons {
+		http.SetCookie(
+			response,
+			switcher.createCookie(req, switcher.CookieName+addonsCookieName, strings.Join(addons, ",")),
+		)
+	}
+
+	http.Redirect(response, req, getRedirectTarget(req), http.StatusFound)
+}
+
+// getAllowedAddons get the full names of the addons listed in values, failing if any of them is not allowed.
+func (switcher *Switcher) getAllowedAddons(values []string, config *Config) ([]string, error) {
+	addons := make([]string, 0, len(values))
+
+	for _, addon := range splitList(strings.Join(values, ",")) {
+		if addon = config.getAddonName(addon); !containsString(switcher.Addons, addon) {
+			return nil, fmt.Errorf("addon %q is not allowed", addon)
+		}
+
+		addons = append(addons, addon)
+	}
+
+	return addons, nil
+}
+
+func (switcher *Switcher) normalizeTheme(theme string, config *Config) string {
+	theme = strings.TrimSpace(theme)
+	if theme == "base" {
+		return config.App + "-base"
+	}
+
+	return theme
+}
+
+// createCookie build a cookie storing value, or one removing the cookie if value is empty.
+func (switcher *Switcher) createCookie(req *http.Request, name, value string) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   cookieMaxAge,
+		Secure:   req.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+
+	return cookie
+}
+
+// getRedirectTarget get the local path the user should be sent back to after updating their selection.
+func getRedirectTarget(req *http.Request) string {
+	if redirect := req.URL.Query().Get("redirect"); isLocalPath(redirect) {
+		return redirect
+	}
+
+	referer, err := url.Parse(req.Referer())
+	if err == nil && referer.Host == req.Host && isLocalPath(referer.RequestURI()) {
+		return referer.RequestURI()
+	}
+
+	return "/"
+}
+
+// isLocalPath verify path stays on the current host to avoid open redirects. Browsers drop control characters
+// and read backslashes as slashes, which would turn paths like '/\t/example.com' into '//example.com'.
+func isLocalPath(path string) bool {
+	for _, char := range path {
+		if char <= ' ' || char == '\\' || (char >= 0x7f && char <= 0x9f) {
+			return false
+		}
+	}
+
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") {
+		return false
+	}
+
+	target, err := url.Parse(path)
+
+	return err == nil && target.Scheme == "" && target.Host == ""
+}
+
+func splitList(value string) []string {
+	result := make([]string, 0)
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
@@ -4,11 +4,16 @@ package traefik_themepark
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 
-	"github.com/packruler/rewrite-body/handler"
+	"github.com/packruler/traefik-themepark/cssutil"
+	"github.com/packruler/traefik-themepark/handler"
+	"github.com/packruler/traefik-themepark/httputil"
+	"github.com/packruler/traefik-themepark/logger"
 )
 
 // Config holds the plugin configuration.
@@ -19,6 +24,64 @@ type Config struct {
 	LogLevel int8     `json:"logLevel,omitempty"`
 	Addons   []string `json:"addons,omitempty"`
 	Target   string   `json:"target,omitempty"`
+
+	// LightTheme and DarkTheme follow the browser's prefers-color-scheme instead of using Theme.
+	LightTheme string `json:"lightTheme,omitempty"`
+	DarkTheme  string `json:"darkTheme,omitempty"`
+
+	// SkipValidation allows custom apps, themes and addons that are not part of the theme.park catalog.
+	SkipValidation bool `json:"skipValidation,omitempty"`
+	// ReservedPath is the path prefix handled by the middleware itself instead of the service.
+	ReservedPath string   `json:"reservedPath,omitempty"`
+	Switcher     Switcher `json:"switcher,omitempty"`
+
+	// MaxBodySize limits in bytes how much of a page is buffered. Larger pages are passed through without a theme.
+	MaxBodySize int64 `json:"maxBodySize,omitempty"`
+	// Streaming injects the stylesheets while the page is received instead of buffering the whole page.
+	Streaming bool `json:"streaming,omitempty"`
+	// StatusCodes lists the status codes and ranges of themed responses, e.g. '200-299,404'. Defaults to all.
+	StatusCodes string `json:"statusCodes,omitempty"`
+	// IncludePaths limits theming to requests with a path matching one of these globs, or regular expressions
+	// with the 'regex:' prefix. ExcludePaths passes requests with a matching path through untouched.
+	IncludePaths []string `json:"includePaths,omitempty"`
+	ExcludePaths []string `json:"excludePaths,omitempty"`
+	// AcceptWildcard themes pages for requests accepting any media type with '*/*' instead of naming HTML.
+	AcceptWildcard bool `json:"acceptWildcard,omitempty"`
+	// Head answers HEAD requests for themed pages with the headers of the themed page. The service gets a HEAD
+	// request for each of them, plus a GET request unless the length is cached for its ETag. Without it the
+	// Content-Length and ETag of the service are removed from HEAD responses for themed pages.
+	Head bool `json:"head,omitempty"`
+	// Compression controls how themed pages are compressed for the client.
+	Compression handler.Compression `json:"compression,omitempty"`
+
+	// Inline embeds the stylesheets in the page instead of linking to them. Fetched stylesheets are cached for InlineTTL.
+	Inline    bool   `json:"inline,omitempty"`
+	InlineTTL string `json:"inlineTtl,omitempty"`
+	// Assets serves theme.park assets below ReservedPath so clients do not need to reach baseUrl.
+	Assets Assets `json:"assets,omitempty"`
+
+	// ConflictMode controls pages that already include theme.park stylesheets: 'append' (default), 'replace' or 'skip'.
+	ConflictMode string `json:"conflictMode,omitempty"`
+
+	// Apps allows theming multiple apps with a single middleware based on request matching rules.
+	Apps []AppRule `json:"apps,omitempty"`
+
+	// defaultTarget is set when Target was not configured so stylesheets are injected with the HTML aware injector.
+	defaultTarget bool
+}
+
+type themeParkHandler struct {
+	next         http.Handler
+	handler      http.Handler
+	profiles     []*profile
+	fallback     *profile
+	reservedPath string
+	conflictMode string
+	// referenceRoots the URLs existing theme.park stylesheets are recognized below.
+	referenceRoots []string
+	styles         *styleCache
+	assets         *assetServer
+	logger         logger.LogWriter
 }
 
 // CreateConfig creates and initializes the plugin configuration.
@@ -28,75 +91,430 @@ func CreateConfig() *Config {
 
 // New creates and returns a new rewrite body plugin instance.
 func New(context context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
-	config.setDefaults()
+	result := &themeParkHandler{
+		next:     next,
+		profiles: make([]*profile, 0, len(config.Apps)),
+		logger:   *logger.CreateLogger(logger.LogLevel(config.LogLevel)),
+	}
+
+	for index := range config.Apps {
+		ruleProfile, err := newRuleProfile(config, &config.Apps[index])
+		if err != nil {
+			return nil, fmt.Errorf("invalid theme.park configuration for apps[%d]: %w", index, err)
+		}
+
+		result.profiles = append(result.profiles, ruleProfile)
+	}
+
+	// Without a top level app requests that do not match any rule are passed through untouched.
+	if config.App != "" || len(config.Apps) == 0 {
+		fallback, err := newProfile(config, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid theme.park configuration: %w", err)
+		}
+
+		result.fallback = fallback
+	}
+
+	if err := result.setupShared(result.getSharedConfig()); err != nil {
+		return nil, fmt.Errorf("invalid theme.park configuration: %w", err)
+	}
+
+	if result.styles != nil {
+		// Stylesheets are fetched in the background so the first requests do not have to wait for them.
+		go result.warmStyles()
+	}
 
 	handlerConfig := &handler.Config{
-		Rewrites: []handler.Rewrite{
-			{
-				Regex:       config.Target,
-				Replacement: config.getReplacementString(),
-			},
+		LogLevel:    config.LogLevel,
+		MaxBodySize: config.MaxBodySize,
+		Streaming:   config.Streaming,
+		Compression: config.Compression,
+		MeasureHead: config.Head,
+		Monitoring: httputil.MonitoringConfig{
+			Methods:        []string{http.MethodGet, http.MethodHead},
+			StatusCodes:    config.StatusCodes,
+			IncludePaths:   config.IncludePaths,
+			ExcludePaths:   config.ExcludePaths,
+			AcceptWildcard: config.AcceptWildcard,
 		},
-		LogLevel: config.LogLevel,
 	}
 
-	return handler.New(context, next, handlerConfig, name)
+	var err error
+
+	result.handler, err = handler.New(
+		context,
+		next,
+		handlerConfig,
+		name,
+		handler.WithRewriter(result.rewrite),
+		handler.WithStreamRewriter(result.rewriteStream),
+		handler.WithVariant(result.getVariant),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
-const replFormat string = "<link " +
-	"rel=\"stylesheet\" " +
-	"type=\"text/css\" " +
-	"href=\"%s/css/base/%s/%s.css\">"
+// getSharedConfig get a config with defaults applied for the settings shared by every profile.
+func (themePark *themeParkHandler) getSharedConfig() *Config {
+	if themePark.fallback != nil {
+		return themePark.fallback.config
+	}
 
-const addonFormatLegacy string = "<link " +
-	"rel=\"stylesheet\" " +
-	"type=\"text/css\" " +
-	"href=\"%s/css/addons/%s/%s-%s/%s-%s.css\">"
+	return themePark.profiles[0].config
+}
+
+// setupShared create the state shared by every profile.
+func (themePark *themeParkHandler) setupShared(config *Config) error {
+	themePark.reservedPath = config.ReservedPath
+
+	if err := validateConflictMode(config.ConflictMode); err != nil {
+		return err
+	}
+
+	themePark.conflictMode = config.ConflictMode
+
+	if config.Assets.isEnabled() {
+		assets, err := newAssetServer(config)
+		if err != nil {
+			return err
+		}
+
+		themePark.assets = assets
+	}
+
+	themePark.referenceRoots = getReferenceRoots(config, themePark.assets)
+
+	if config.Inline {
+		styles, err := newStyleCache(config.InlineTTL, themePark.assets)
+		if err != nil {
+			return err
+		}
+
+		themePark.styles = styles
+	}
+
+	return nil
+}
+
+func (themePark *themeParkHandler) ServeHTTP(response http.ResponseWriter, req *http.Request) {
+	if themePark.assets != nil && themePark.isAssetPath(req.URL.Path) {
+		themePark.assets.ServeHTTP(response, req)
+
+		return
+	}
+
+	requestProfile := themePark.getProfile(req)
+	if requestProfile == nil {
+		themePark.next.ServeHTTP(response, req)
+
+		return
+	}
+
+	config := requestProfile.config
+
+	if config.Switcher.Enabled {
+		switch req.URL.Path {
+		case config.ReservedPath + switcherSetPath:
+			config.Switcher.handleSet(response, req, config)
+
+			return
+		case config.ReservedPath + pickerPath:
+			servePicker(response)
+
+			return
+		}
+
+		// The injected theme depends on the cookie so caches must not share responses between users.
+		response.Header().Add("Vary", "Cookie")
+	}
+
+	themePark.handler.ServeHTTP(response, withProfile(req, requestProfile))
+}
+
+// isAssetPath check if requestPath is below the reserved path without being one of the switcher endpoints.
+func (themePark *themeParkHandler) isAssetPath(requestPath string) bool {
+	switch requestPath {
+	case themePark.reservedPath + switcherSetPath, themePark.reservedPath + pickerPath:
+		return false
+	default:
+		return strings.HasPrefix(requestPath, themePark.reservedPath+"/")
+	}
+}
+
+// rewrite inject the stylesheets selected for the request into the response body.
+func (themePark *themeParkHandler) rewrite(req *http.Request, body []byte) []byte {
+	requestProfile := getRequestProfile(req)
+	if requestProfile != nil && requestProfile.detected != nil {
+		requestProfile = requestProfile.detected[detectApp(body)]
+	}
+
+	if requestProfile == nil {
+		return body
+	}
+
+	switch themePark.conflictMode {
+	case conflictSkip:
+		if hasThemeParkReference(body, themePark.referenceRoots) {
+			return body
+		}
+	case conflictReplace:
+		body = removeThemeParkReferences(body, themePark.referenceRoots)
+	}
+
+	config := requestProfile.config
+	switcher := &config.Switcher
+	selected := switcher.getSelection(req, config)
+
+	stylesheets := themePark.getStylesheetString(selected)
+
+	if requestProfile.target != nil {
+		// Stylesheets may contain '$' which would be interpreted as a capture group reference.
+		replacement := strings.ReplaceAll(stylesheets, "$", "$$") + selected.Target
+		body = requestProfile.target.ReplaceAll(body, []byte(replacement))
+	} else {
+		body = injectHTML(body, selected.getTargetElement(), []byte(stylesheets))
+	}
+
+	if switcher.Picker {
+		body = injectHTML(body, "body", []byte(switcher.getPickerTag(config, selected)))
+	}
 
-const addonFormat string = "<link " +
+	return body
+}
+
+// getVariant identify the stylesheets and picker injected for the request so themed pages get their own ETag.
+func (themePark *themeParkHandler) getVariant(req *http.Request) string {
+	requestProfile := getRequestProfile(req)
+	if requestProfile == nil {
+		return ""
+	}
+
+	config := requestProfile.config
+	switcher := &config.Switcher
+	selected := switcher.getSelection(req, config)
+
+	// Detected apps are not known yet but follow from the page, which the ETag of the service already covers.
+	// The links identify inlined stylesheets too, which spares fetching them for every request.
+	hash := fnv.New32a()
+	_, _ = fmt.Fprint(hash, themePark.conflictMode, selected.Target, themePark.styles != nil)
+	_, _ = fmt.Fprint(hash, selected.getStylesheetString())
+
+	if switcher.Picker {
+		_, _ = fmt.Fprint(hash, switcher.getPickerTag(config, selected))
+	}
+
+	return strconv.FormatUint(uint64(hash.Sum32()), 36)
+}
+
+// rewriteStream create the injector for the stylesheets selected for the request when the page can be streamed.
+func (themePark *themeParkHandler) rewriteStream(req *http.Request) httputil.StreamRewriter {
+	requestProfile := getRequestProfile(req)
+
+	// Detecting the app and regular expression targets need the whole page.
+	if requestProfile == nil || requestProfile.detected != nil || requestProfile.target != nil {
+		return nil
+	}
+
+	config := requestProfile.config
+	switcher := &config.Switcher
+	selected := switcher.getSelection(req, config)
+	injector := &streamInjector{}
+	inspector := newReferenceInspector(themePark.referenceRoots)
+
+	if themePark.conflictMode != conflictAppend {
+		// References that were already sent can not be removed so 'replace' leaves such pages untouched like 'skip'.
+		injector.inspect = inspector.inspect
+	}
+
+	injector.injections = append(injector.injections, streamInjection{
+		element: selected.getTargetElement(),
+		content: func() []byte {
+			if inspector.referenced {
+				return nil
+			}
+
+			return []byte(themePark.getStylesheetString(selected))
+		},
+	})
+
+	if switcher.Picker {
+		injector.injections = append(injector.injections, streamInjection{
+			element: "body",
+			content: func() []byte {
+				if inspector.referenced {
+					return nil
+				}
+
+				return []byte(switcher.getPickerTag(config, selected))
+			},
+		})
+	}
+
+	return injector
+}
+
+// warmStyles fetch the stylesheets to inline for every profile. Profiles detecting the app are skipped
+// since they could use the stylesheets of any app.
+func (themePark *themeParkHandler) warmStyles() {
+	profiles := append([]*profile{}, themePark.profiles...)
+	if themePark.fallback != nil {
+		profiles = append(profiles, themePark.fallback)
+	}
+
+	for _, warmed := range profiles {
+		if warmed.detected != nil {
+			continue
+		}
+
+		if _, err := themePark.styles.getInlineString(warmed.config); err != nil {
+			themePark.logger.LogWarningf("Unable to fetch stylesheets to inline: %v", err)
+		}
+	}
+}
+
+// getStylesheetString get the stylesheets injected into the page, embedding them when inline is enabled.
+func (themePark *themeParkHandler) getStylesheetString(selected *Config) string {
+	if themePark.styles == nil {
+		return selected.getStylesheetString()
+	}
+
+	inlined, err := themePark.styles.getInlineString(selected)
+	if err != nil {
+		themePark.logger.LogWarningf("Unable to inline stylesheets, linking them instead: %v", err)
+
+		return selected.getStylesheetString()
+	}
+
+	return inlined
+}
+
+const baseHrefFormat string = "%s/css/base/%s/%s.css"
+
+const addonHrefFormat string = "%s/css/addons/%s/%s/%s.css"
+
+const linkFormat string = "<link " +
 	"rel=\"stylesheet\" " +
 	"type=\"text/css\" " +
-	"href=\"%s/css/addons/%s/%s/%s.css\">"
+	"href=\"%s\"%s>"
 
-func (config *Config) getReplacementString() string {
-	var stringBuilder strings.Builder
+// stylesheet a stylesheet to inject with an optional media query.
+type stylesheet struct {
+	href  string
+	media string
+	// base is set for the stylesheet of the theme, as opposed to those of addons.
+	base bool
+}
+
+// getMediaAttribute get the media attribute of the stylesheet, or an empty string for every media.
+func (sheet stylesheet) getMediaAttribute() string {
+	if sheet.media == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(" media=\"%s\"", sheet.media)
+}
 
-	stringBuilder.WriteString(fmt.Sprintf(replFormat, config.BaseURL, config.App, config.Theme))
+// getStylesheets get the stylesheets, base theme first and addons after, for the configuration.
+func (config *Config) getStylesheets() []stylesheet {
+	result := make([]stylesheet, 0, len(config.Addons)+2)
+
+	if config.isColorSchemeBased() {
+		result = append(result,
+			stylesheet{
+				href:  fmt.Sprintf(baseHrefFormat, config.BaseURL, config.App, config.LightTheme),
+				media: "(prefers-color-scheme: light)",
+				base:  true,
+			},
+			stylesheet{
+				href:  fmt.Sprintf(baseHrefFormat, config.BaseURL, config.App, config.DarkTheme),
+				media: "(prefers-color-scheme: dark)",
+				base:  true,
+			},
+		)
+	} else {
+		result = append(result, stylesheet{
+			href: fmt.Sprintf(baseHrefFormat, config.BaseURL, config.App, config.Theme),
+			base: true,
+		})
+	}
 
 	for _, addon := range config.Addons {
-		if strings.HasPrefix(addon, config.App) {
-			stringBuilder.WriteString(fmt.Sprintf(addonFormat, config.BaseURL, config.App, addon, addon))
-		} else {
-			stringBuilder.WriteString(
-				fmt.Sprintf(
-					addonFormatLegacy,
-					config.BaseURL,
-					config.App,
-					config.App,
-					addon,
-					config.App,
-					addon,
-				),
-			)
-		}
+		addonName := config.getAddonName(addon)
+		result = append(result, stylesheet{
+			href: fmt.Sprintf(addonHrefFormat, config.BaseURL, config.App, addonName, addonName),
+		})
 	}
 
-	stringBuilder.WriteString(config.Target)
+	return result
+}
+
+func (config *Config) getReplacementString() string {
+	return config.getStylesheetString() + config.Target
+}
+
+// getStylesheetString get the links to the stylesheets of the configuration.
+func (config *Config) getStylesheetString() string {
+	var stringBuilder strings.Builder
+
+	for _, sheet := range config.getStylesheets() {
+		stringBuilder.WriteString(fmt.Sprintf(linkFormat, sheet.href, sheet.getMediaAttribute()))
+	}
 
 	return stringBuilder.String()
 }
 
 func (config *Config) setDefaults() {
 	if config.BaseURL == "" {
-		config.BaseURL = "https://theme-park.dev"
+		config.BaseURL = cssutil.ThemeParkURL
 	}
 
 	if config.Theme == "" || config.Theme == "base" {
 		config.Theme = config.App + "-base"
 	}
 
+	if config.isColorSchemeBased() {
+		config.LightTheme = config.getThemeOrDefault(config.LightTheme)
+		config.DarkTheme = config.getThemeOrDefault(config.DarkTheme)
+	}
+
 	if config.Target == "" {
 		config.Target = config.getRegexTarget()
+		config.defaultTarget = true
+	}
+
+	if config.ConflictMode == "" {
+		config.ConflictMode = conflictAppend
+	}
+
+	if config.ReservedPath == "" {
+		config.ReservedPath = "/__themepark"
+	}
+
+	config.ReservedPath = strings.TrimSuffix(config.ReservedPath, "/")
+
+	config.Assets.setDefaults(config)
+
+	config.Switcher.setDefaults(config)
+}
+
+// isColorSchemeBased check if the theme should follow the browser's prefers-color-scheme.
+func (config *Config) isColorSchemeBased() bool {
+	return config.LightTheme != "" || config.DarkTheme != ""
+}
+
+// getThemeOrDefault get the provided theme using Theme when empty and resolving 'base' to the app's base theme.
+func (config *Config) getThemeOrDefault(theme string) string {
+	switch theme {
+	case "":
+		return config.Theme
+	case "base":
+		return config.App + "-base"
+	default:
+		return theme
 	}
 }
 
@@ -118,10 +536,15 @@ func getBodyBasedAppsRegex() string {
 }
 
 func (config *Config) getRegexTarget() string {
+	return fmt.Sprintf("</%s>", config.getTargetElement())
+}
+
+// getTargetElement get the element, 'head' or 'body', the stylesheets are injected at the end of.
+func (config *Config) getTargetElement() string {
 	match, _ := regexp.MatchString(getBodyBasedAppsRegex(), config.App)
 	if match {
-		return "</body>"
+		return "body"
 	}
 
-	return "</head>"
+	return "head"
 }
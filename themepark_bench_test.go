@@ -0,0 +1,99 @@
+package traefik_themepark
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/packruler/traefik-themepark/compressutil"
+)
+
+// benchmarkPageSizes typical sizes of themed pages, from small dashboards to large log views.
+var benchmarkPageSizes = []int{50 * 1024, 500 * 1024, 2 * 1024 * 1024}
+
+// getBenchmarkPage get an HTML page of roughly size bytes.
+func getBenchmarkPage(size int) []byte {
+	row := "<tr><td class=\"title\">Episode</td><td>2022-08-01 20:00</td><td>Downloaded</td></tr>\n"
+	rows := strings.Repeat(row, size/len(row))
+
+	return []byte("<html><head><title>Sonarr</title></head><body><table>" + rows + "</table></body></html>")
+}
+
+func BenchmarkServeHTTP(b *testing.B) {
+	encodings := []string{compressutil.Identity, compressutil.Gzip, compressutil.Deflate, compressutil.Brotli}
+
+	for _, encoding := range encodings {
+		for _, size := range benchmarkPageSizes {
+			page, _ := compressutil.Encode(getBenchmarkPage(size), encoding, compressutil.DefaultLevel)
+
+			b.Run(encoding+"/"+strconv.Itoa(size/1024)+"KB", func(b *testing.B) {
+				benchmarkServeHTTP(b, Config{App: "sonarr", Theme: "nord"}, encoding, page)
+			})
+		}
+	}
+}
+
+func BenchmarkServeHTTPStreaming(b *testing.B) {
+	for _, size := range benchmarkPageSizes {
+		page, _ := compressutil.Encode(getBenchmarkPage(size), compressutil.Gzip, compressutil.DefaultLevel)
+
+		b.Run(compressutil.Gzip+"/"+strconv.Itoa(size/1024)+"KB", func(b *testing.B) {
+			benchmarkServeHTTP(b, Config{App: "sonarr", Theme: "nord", Streaming: true}, compressutil.Gzip, page)
+		})
+	}
+}
+
+func benchmarkServeHTTP(b *testing.B, config Config, encoding string, page []byte) {
+	b.Helper()
+
+	next := func(responseWriter http.ResponseWriter, _ *http.Request) {
+		responseWriter.Header().Set("Content-Type", "text/html")
+		responseWriter.Header().Set("Content-Encoding", encoding)
+
+		// Services usually write the body in chunks.
+		for start := 0; start < len(page); start += 32 * 1024 {
+			end := start + 32*1024
+			if end > len(page) {
+				end = len(page)
+			}
+
+			_, _ = responseWriter.Write(page[start:end])
+		}
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		handler.ServeHTTP(&discardResponseWriter{header: http.Header{}}, req)
+	}
+}
+
+// discardResponseWriter a http.ResponseWriter dropping the body so only the middleware is measured.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (writer *discardResponseWriter) Header() http.Header {
+	return writer.header
+}
+
+func (writer *discardResponseWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+func (writer *discardResponseWriter) WriteHeader(int) {}
+
+func (writer *discardResponseWriter) Flush() {}
@@ -2,22 +2,140 @@ package traefik_themepark
 
 import (
 	"bytes"
+	"compress/flate"
+	"compress/zlib"
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/packruler/rewrite-body/compressutil"
+	"github.com/packruler/traefik-themepark/compressutil"
+	"github.com/packruler/traefik-themepark/cssutil"
+	"github.com/packruler/traefik-themepark/handler"
 )
 
+// replFormat the link to the base theme %[3]s of the app %[2]s below the base URL %[1]s.
+const replFormat string = "<link rel=\"stylesheet\" type=\"text/css\" href=\"%s/css/base/%s/%s.css\">"
+
 func compressString(value string, encoding string) string {
-	compressed, _ := compressutil.Encode([]byte(value), encoding)
+	compressed, _ := compressutil.Encode([]byte(value), encoding, compressutil.DefaultLevel)
 
 	return string(compressed)
 }
 
+func TestCompressRoundTrip(t *testing.T) {
+	body := "<head><script></script></head><body>" + strings.Repeat("<div>theme.park</div>", 100) + "</body>"
+
+	encodings := []string{
+		compressutil.Gzip,
+		compressutil.Deflate,
+		compressutil.Brotli,
+		compressutil.Identity,
+	}
+
+	// Levels above the highest level of an algorithm use its highest level.
+	levels := []int{compressutil.DefaultLevel, 1, 9, 11, 22}
+
+	for _, encoding := range encodings {
+		for _, level := range levels {
+			encoding, level := encoding, level
+
+			t.Run(encoding+" level "+strconv.Itoa(level), func(t *testing.T) {
+				compressed, err := compressutil.Encode([]byte(body), encoding, level)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if encoding != compressutil.Identity && len(compressed) >= len(body) {
+					t.Errorf("compressed size %d is not smaller than %d", len(compressed), len(body))
+				}
+
+				result, err := compressutil.Decode(bytes.NewBuffer(compressed), encoding)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if string(result) != body {
+					t.Errorf("result: '%s' | expected: '%s'", result, body)
+				}
+			})
+		}
+	}
+}
+
+func TestDeflateFormats(t *testing.T) {
+	page := "<head></head><body>" + strings.Repeat("<p>theme.park</p>", 50) + "</body>"
+
+	var zlibBody bytes.Buffer
+
+	zlibWriter, _ := zlib.NewWriterLevel(&zlibBody, zlib.BestCompression)
+	_, _ = zlibWriter.Write([]byte(page))
+	_ = zlibWriter.Close()
+
+	var rawBody bytes.Buffer
+
+	flateWriter, _ := flate.NewWriter(&rawBody, flate.BestSpeed)
+	_, _ = flateWriter.Write([]byte(page))
+	_ = flateWriter.Close()
+
+	tests := []struct {
+		desc      string
+		body      []byte
+		format    string
+		streaming bool
+	}{
+		{desc: "zlib", body: zlibBody.Bytes(), format: compressutil.Deflate},
+		{desc: "raw deflate", body: rawBody.Bytes(), format: compressutil.RawDeflate},
+		{desc: "streamed zlib", body: zlibBody.Bytes(), format: compressutil.Deflate, streaming: true},
+		{desc: "streamed raw deflate", body: rawBody.Bytes(), format: compressutil.RawDeflate, streaming: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, _ *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/html")
+				responseWriter.Header().Set("Content-Encoding", compressutil.Deflate)
+				_, _ = responseWriter.Write(test.body)
+			}
+
+			config := Config{App: "sonarr", Theme: "nord", Streaming: test.streaming}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept", "text/html")
+			req.Header.Set("Accept-Encoding", compressutil.Deflate)
+
+			handler.ServeHTTP(recorder, req)
+
+			if format := compressutil.GetFormat(recorder.Body.Bytes(), compressutil.Deflate); format != test.format {
+				t.Errorf("format: %s | expected: %s", format, test.format)
+			}
+
+			body, err := compressutil.Decode(recorder.Body, compressutil.Deflate)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := strings.Replace(page, "</body>", config.getReplacementString(), 1)
+			if string(body) != expected {
+				t.Errorf("got body: %s\n wanted: %s", body, expected)
+			}
+		})
+	}
+}
+
 func TestServeHTTP(t *testing.T) {
 	tests := []struct {
 		desc            string
@@ -33,7 +151,7 @@ func TestServeHTTP(t *testing.T) {
 	}{
 		{
 			desc:    "should replace </head> properly with no whitespace",
-			config:  Config{App: "placeholder", Theme: "dark"},
+			config:  Config{App: "placeholder", Theme: "dark", SkipValidation: true},
 			resBody: "<head><script></script></head><body></body>",
 			expResBody: "<head><script></script>" +
 				fmt.Sprintf(replFormat, "https://theme-park.dev", "placeholder", "dark") +
@@ -44,7 +162,7 @@ func TestServeHTTP(t *testing.T) {
 		},
 		{
 			desc:   "should replace </head> properly with on new line",
-			config: Config{App: "placeholder", Theme: "dark"},
+			config: Config{App: "placeholder", Theme: "dark", SkipValidation: true},
 			resBody: `<head>
 			<script></script>
 			</head>
@@ -59,7 +177,7 @@ func TestServeHTTP(t *testing.T) {
 		},
 		{
 			desc:            "should compress to gzip with proper header",
-			config:          Config{App: "placeholder", Theme: "dark"},
+			config:          Config{App: "placeholder", Theme: "dark", SkipValidation: true},
 			contentEncoding: compressutil.Gzip,
 			resBody:         compressString("<head><script></script></head><body></body>", compressutil.Gzip),
 			expResBody: compressString(
@@ -74,7 +192,7 @@ func TestServeHTTP(t *testing.T) {
 		},
 		{
 			desc:            "should compress to zlib with proper header",
-			config:          Config{App: "placeholder", Theme: "dark"},
+			config:          Config{App: "placeholder", Theme: "dark", SkipValidation: true},
 			contentEncoding: compressutil.Deflate,
 			resBody:         compressString("<head><script></script></head><body></body>", compressutil.Deflate),
 			expResBody: compressString(
@@ -88,9 +206,35 @@ func TestServeHTTP(t *testing.T) {
 			acceptContent:  "text/html",
 			contentType:    "text/html",
 		},
+		{
+			desc:            "should compress to brotli with proper header",
+			config:          Config{App: "placeholder", Theme: "dark", SkipValidation: true},
+			contentEncoding: compressutil.Brotli,
+			resBody:         compressString("<head><script></script></head><body></body>", compressutil.Brotli),
+			expResBody: compressString(
+				"<head><script></script>"+
+					fmt.Sprintf(replFormat, "https://theme-park.dev", "placeholder", "dark")+
+					"</head>"+
+					"<body></body>",
+				compressutil.Brotli,
+			),
+			acceptEncoding: compressutil.Brotli,
+			acceptContent:  "text/html",
+			contentType:    "text/html",
+		},
+		{
+			desc:            "should not modify pages with unsupported encodings",
+			config:          Config{App: "placeholder", Theme: "dark", SkipValidation: true},
+			contentEncoding: "zstd",
+			resBody:         "<head><script></script></head><body></body>",
+			expResBody:      "<head><script></script></head><body></body>",
+			acceptEncoding:  "zstd",
+			acceptContent:   "text/html",
+			contentType:     "text/html",
+		},
 		{
 			desc:    "should not compress if not encoded from service",
-			config:  Config{App: "placeholder", Theme: "dark"},
+			config:  Config{App: "placeholder", Theme: "dark", SkipValidation: true},
 			resBody: "<head><script></script></head><body></body>",
 			expResBody: "<head><script></script>" +
 				fmt.Sprintf(replFormat, "https://theme-park.dev", "placeholder", "dark") +
@@ -100,9 +244,17 @@ func TestServeHTTP(t *testing.T) {
 			acceptContent:  "text/html",
 			contentType:    "text/html",
 		},
+		{
+			desc:          "should not modify XHTML pages parsed as XML",
+			config:        Config{App: "placeholder", Theme: "dark", SkipValidation: true},
+			resBody:       "<html xmlns=\"http://www.w3.org/1999/xhtml\"><head></head><body></body></html>",
+			expResBody:    "<html xmlns=\"http://www.w3.org/1999/xhtml\"><head></head><body></body></html>",
+			acceptContent: "text/html,application/xhtml+xml",
+			contentType:   "application/xhtml+xml",
+		},
 		{
 			desc:    "should use custom baseURL",
-			config:  Config{App: "placeholder", Theme: "dark", BaseURL: "http://test.com"},
+			config:  Config{App: "placeholder", Theme: "dark", BaseURL: "http://test.com", SkipValidation: true},
 			resBody: "<head><script></script></head><body></body>",
 			expResBody: "<head><script></script>" +
 				fmt.Sprintf(replFormat, "http://test.com", "placeholder", "dark") +
@@ -198,6 +350,28 @@ func TestReplacementString(t *testing.T) {
 				"<link rel=\"stylesheet\" type=\"text/css\" href=\"https://theme-park.dev/css/addons/placeholder/placeholder-4k-logo/placeholder-4k-logo.css\">" +
 				"</head>",
 		},
+		{
+			desc:   "Light and dark placeholder Themes",
+			config: Config{App: "placeholder", LightTheme: "nord", DarkTheme: "dracula"},
+			expected: "<link rel=\"stylesheet\" type=\"text/css\" href=\"https://theme-park.dev/css/base/placeholder/nord.css\" media=\"(prefers-color-scheme: light)\">" +
+				"<link rel=\"stylesheet\" type=\"text/css\" href=\"https://theme-park.dev/css/base/placeholder/dracula.css\" media=\"(prefers-color-scheme: dark)\">" +
+				"</head>",
+		},
+		{
+			desc:   "Dark placeholder Theme falls back to Theme for light with addons",
+			config: Config{App: "placeholder", Theme: "base", DarkTheme: "nord", Addons: []string{"4k-logo"}},
+			expected: "<link rel=\"stylesheet\" type=\"text/css\" href=\"https://theme-park.dev/css/base/placeholder/placeholder-base.css\" media=\"(prefers-color-scheme: light)\">" +
+				"<link rel=\"stylesheet\" type=\"text/css\" href=\"https://theme-park.dev/css/base/placeholder/nord.css\" media=\"(prefers-color-scheme: dark)\">" +
+				"<link rel=\"stylesheet\" type=\"text/css\" href=\"https://theme-park.dev/css/addons/placeholder/placeholder-4k-logo/placeholder-4k-logo.css\">" +
+				"</head>",
+		},
+		{
+			desc:   "Light base placeholder Theme",
+			config: Config{App: "placeholder", Theme: "nord", LightTheme: "base"},
+			expected: "<link rel=\"stylesheet\" type=\"text/css\" href=\"https://theme-park.dev/css/base/placeholder/placeholder-base.css\" media=\"(prefers-color-scheme: light)\">" +
+				"<link rel=\"stylesheet\" type=\"text/css\" href=\"https://theme-park.dev/css/base/placeholder/nord.css\" media=\"(prefers-color-scheme: dark)\">" +
+				"</head>",
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.desc, func(t *testing.T) {
@@ -296,3 +470,2761 @@ func TestRegexTarget(t *testing.T) {
 		})
 	}
 }
+
+func TestInjectHTML(t *testing.T) {
+	tests := []struct {
+		desc     string
+		element  string
+		body     string
+		expected string
+	}{
+		{
+			desc:     "should inject before the closing head tag",
+			element:  "head",
+			body:     "<html><head><title>App</title></head><body></body></html>",
+			expected: "<html><head><title>App</title>[css]</head><body></body></html>",
+		},
+		{
+			desc:     "should ignore closing tags in scripts",
+			element:  "head",
+			body:     "<head><script>document.write('</head>')</script></head><body></body>",
+			expected: "<head><script>document.write('</head>')</script>[css]</head><body></body>",
+		},
+		{
+			desc:     "should ignore closing tags in comments",
+			element:  "body",
+			body:     "<head></head><body><!-- </body> --></body>",
+			expected: "<head></head><body><!-- </body> -->[css]</body>",
+		},
+		{
+			desc:     "should ignore closing tags in srcdoc attributes",
+			element:  "body",
+			body:     "<body><iframe srcdoc=\"<body></body>\"></iframe></body>",
+			expected: "<body><iframe srcdoc=\"<body></body>\"></iframe>[css]</body>",
+		},
+		{
+			desc:     "should ignore closing tags in titles and textareas",
+			element:  "head",
+			body:     "<head><title></head></title></head><body><textarea></body></textarea></body>",
+			expected: "<head><title></head></title>[css]</head><body><textarea></body></textarea></body>",
+		},
+		{
+			desc:     "should match tags case insensitively",
+			element:  "body",
+			body:     "<HTML><BODY><P>Ünïcode</P></BODY ></HTML>",
+			expected: "<HTML><BODY><P>Ünïcode</P>[css]</BODY ></HTML>",
+		},
+		{
+			desc:     "should inject before the last closing body tag",
+			element:  "body",
+			body:     "<body></body><div></div></body>",
+			expected: "<body></body><div></div>[css]</body>",
+		},
+		{
+			desc:     "should inject before the body without a closing head tag",
+			element:  "head",
+			body:     "<!DOCTYPE html><title>App</title><body></body>",
+			expected: "<!DOCTYPE html><title>App</title>[css]<body></body>",
+		},
+		{
+			desc:     "should inject before the closing html tag without a closing body tag",
+			element:  "body",
+			body:     "<html><body><div></div></html>",
+			expected: "<html><body><div></div>[css]</html>",
+		},
+		{
+			desc:     "should append without any tag",
+			element:  "head",
+			body:     "<div>a < b</div>",
+			expected: "<div>a < b</div>[css]",
+		},
+		{
+			desc:     "should append when a script is not closed",
+			element:  "body",
+			body:     "<body><script>'</body>'",
+			expected: "<body><script>'</body>'[css]",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			result := string(injectHTML([]byte(test.body), test.element, []byte("[css]")))
+			if result != test.expected {
+				t.Errorf("result: '%s' | expected: '%s'", result, test.expected)
+			}
+		})
+	}
+}
+
+func TestTargetOverride(t *testing.T) {
+	config := Config{App: "sonarr", Theme: "dark", Target: "</footer>"}
+
+	next := func(responseWriter http.ResponseWriter, _ *http.Request) {
+		responseWriter.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprint(responseWriter, "<body><footer></footer><footer></footer></body>")
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html")
+
+	handler.ServeHTTP(recorder, req)
+
+	replacement := config.getReplacementString()
+	expected := "<body><footer>" + replacement + "<footer>" + replacement + "</body>"
+
+	if recorder.Body.String() != expected {
+		t.Errorf("got body: %s\n wanted: %s", recorder.Body.String(), expected)
+	}
+}
+
+func TestStreamInjector(t *testing.T) {
+	tests := []struct {
+		desc     string
+		element  string
+		body     string
+		expected string
+	}{
+		{
+			desc:     "should inject before the closing head tag",
+			element:  "head",
+			body:     "<html><head><script>if (a </head>) {}</script><!-- </head> --></HEAD><body></body></html>",
+			expected: "<html><head><script>if (a </head>) {}</script><!-- </head> -->[css]</HEAD><body></body></html>",
+		},
+		{
+			desc:     "should inject before the body without a closing head tag",
+			element:  "head",
+			body:     "<title></head></title><body data-x=\"</head>\"></body>",
+			expected: "<title></head></title>[css]<body data-x=\"</head>\"></body>",
+		},
+		{
+			desc:     "should inject before the first closing body tag",
+			element:  "body",
+			body:     "<head></head><body><textarea></body></textarea></body></html>",
+			expected: "<head></head><body><textarea></body></textarea>[css]</body></html>",
+		},
+		{
+			desc:     "should append without any tag",
+			element:  "body",
+			body:     "<div>a < b</div>",
+			expected: "<div>a < b</div>[css]",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			// Every chunk size splits tags, comments and scripts at a different position.
+			for size := 1; size <= len(test.body); size++ {
+				injector := &streamInjector{injections: []streamInjection{{
+					element: test.element,
+					content: func() []byte { return []byte("[css]") },
+				}}}
+
+				var result []byte
+
+				for start := 0; start < len(test.body); start += size {
+					end := start + size
+					if end > len(test.body) {
+						end = len(test.body)
+					}
+
+					result = append(result, injector.Write([]byte(test.body[start:end]))...)
+				}
+
+				result = append(result, injector.Close()...)
+
+				if string(result) != test.expected {
+					t.Fatalf("chunk size: %d | result: '%s' | expected: '%s'", size, result, test.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestReferenceInspector(t *testing.T) {
+	link := `<link rel="stylesheet" href="https://theme-park.dev/css/base/sonarr/nord.css">`
+
+	tests := []struct {
+		desc     string
+		body     string
+		expected bool
+	}{
+		{
+			desc:     "should find links",
+			body:     "<html><head><title>a</title>" + link + "</head><body></body></html>",
+			expected: true,
+		},
+		{
+			desc:     "should find imports of style elements",
+			body:     `<head><style>body {} @import url("https://theme-park.dev/css/base/sonarr/nord.css");</style></head>`,
+			expected: true,
+		},
+		{
+			desc:     "should find injected pickers",
+			body:     `<head></head><body><script data-themepark-picker>let a = "</head>";</script></body>`,
+			expected: true,
+		},
+		{
+			desc:     "should ignore references in scripts and comments",
+			body:     "<head><script>let a = '" + link + "';</script><!-- " + link + " --></head>",
+			expected: false,
+		},
+		{
+			desc:     "should ignore unrelated stylesheets",
+			body:     `<head><link rel="stylesheet" href="/static/css/base/main.css"><style>p {}</style></head>`,
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			// Every segment size splits tags, imports and scripts at a different position.
+			for size := 1; size <= len(test.body); size++ {
+				inspector := newReferenceInspector([]string{cssutil.ThemeParkURL})
+
+				for start := 0; start < len(test.body); start += size {
+					end := start + size
+					if end > len(test.body) {
+						end = len(test.body)
+					}
+
+					inspector.inspect([]byte(test.body[start:end]))
+				}
+
+				if inspector.referenced != test.expected {
+					t.Fatalf("segment size: %d | referenced: %t | expected: %t", size, inspector.referenced, test.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestStreaming(t *testing.T) {
+	tests := []struct {
+		desc     string
+		encoding string
+		config   Config
+	}{
+		{
+			desc:   "identity",
+			config: Config{App: "sonarr", Theme: "nord", Streaming: true},
+		},
+		{
+			desc:     "gzip",
+			encoding: compressutil.Gzip,
+			config:   Config{App: "sonarr", Theme: "nord", Streaming: true},
+		},
+		{
+			desc:     "brotli",
+			encoding: compressutil.Brotli,
+			config:   Config{App: "sonarr", Theme: "nord", Streaming: true},
+		},
+		{
+			desc:     "deflate with picker",
+			encoding: compressutil.Deflate,
+			config:   Config{App: "sonarr", Theme: "nord", Streaming: true, Switcher: Switcher{Picker: true}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			chunks := []string{"<html><head></head><bo", "dy><div>loading</div>", "</body></html>"}
+
+			next := func(responseWriter http.ResponseWriter, _ *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/html")
+				responseWriter.Header().Set("Content-Encoding", test.encoding)
+
+				encoder, _ := compressutil.NewWriter(responseWriter, test.encoding, compressutil.DefaultLevel)
+
+				for index, chunk := range chunks {
+					_, _ = encoder.Write([]byte(chunk))
+					_ = encoder.Flush()
+
+					// Yaegi only keeps the Hijacker of the ResponseWriter passed to the service, not its Flusher.
+					flusher, ok := responseWriter.(http.Flusher)
+					if !ok {
+						continue
+					}
+
+					flusher.Flush()
+
+					// Everything before the injection point reaches the client as soon as it is flushed.
+					if index == 1 && test.encoding == "" && recorder.Body.String() != chunks[0]+chunks[1] {
+						t.Errorf("flushed body: %s\n wanted: %s", recorder.Body.String(), chunks[0]+chunks[1])
+					}
+				}
+
+				_ = encoder.Close()
+			}
+
+			config := test.config
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept", "text/html")
+
+			handler.ServeHTTP(recorder, req)
+
+			body, err := compressutil.Decode(recorder.Body, test.encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			content := config.getStylesheetString()
+			if config.Switcher.Picker {
+				content += config.Switcher.getPickerTag(&config, &config)
+			}
+
+			expected := "<html><head></head><body><div>loading</div>" + content + "</body></html>"
+			if string(body) != expected {
+				t.Errorf("got body: %s\n wanted: %s", body, expected)
+			}
+		})
+	}
+}
+
+func TestStreamingAbort(t *testing.T) {
+	page := compressString("<html><head></head><body>"+strings.Repeat("<div>theme.park</div>", 64)+"</body></html>",
+		compressutil.Gzip)
+
+	next := func(responseWriter http.ResponseWriter, _ *http.Request) {
+		responseWriter.Header().Set("Content-Type", "text/html")
+		responseWriter.Header().Set("Content-Encoding", compressutil.Gzip)
+
+		// The body ends in the middle of the compressed data.
+		_, _ = fmt.Fprint(responseWriter, page[:len(page)/2])
+	}
+
+	config := Config{App: "sonarr", Theme: "nord", Streaming: true}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("Accept-Encoding", compressutil.Gzip)
+
+	// The connection is aborted so the client does not take the truncated page for a complete one.
+	// The panic is compared by its message since Yaegi recovers it wrapped in a reflect.Value.
+	defer func() {
+		if recovered := recover(); fmt.Sprint(recovered) != http.ErrAbortHandler.Error() {
+			t.Errorf("recovered: %v\n wanted: %v", recovered, http.ErrAbortHandler)
+		}
+	}()
+
+	handler.ServeHTTP(recorder, req)
+}
+
+func TestMaxBodySize(t *testing.T) {
+	config := Config{App: "sonarr", Theme: "nord"}
+	config.setDefaults()
+
+	tests := []struct {
+		desc     string
+		encoding string
+		chunks   []string
+		expected string
+	}{
+		{
+			desc:     "should rewrite bodies within the limit",
+			chunks:   []string{"<head></head>", "<body></body>"},
+			expected: "<head></head><body>" + config.getReplacementString(),
+		},
+		{
+			desc:     "should pass through bodies over the limit",
+			chunks:   []string{"<head></head>", "<body>", strings.Repeat("a", 32), "</body>"},
+			expected: "<head></head><body>" + strings.Repeat("a", 32) + "</body>",
+		},
+		{
+			desc:     "should pass through compressed bodies over the limit unchanged",
+			encoding: compressutil.Gzip,
+			chunks:   []string{compressString("<head></head><body>"+strings.Repeat("ab", 64)+"</body>", compressutil.Gzip)},
+			expected: compressString("<head></head><body>"+strings.Repeat("ab", 64)+"</body>", compressutil.Gzip),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, _ *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/html")
+				responseWriter.Header().Set("Content-Encoding", test.encoding)
+
+				for _, chunk := range test.chunks {
+					_, _ = fmt.Fprint(responseWriter, chunk)
+				}
+			}
+
+			config := Config{App: "sonarr", Theme: "nord", MaxBodySize: 32}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept", "text/html")
+			req.Header.Set("Accept-Encoding", compressutil.Gzip)
+
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Body.String() != test.expected {
+				t.Errorf("got body: %q\n wanted: %q", recorder.Body.String(), test.expected)
+			}
+		})
+	}
+}
+
+func TestCompressionNegotiate(t *testing.T) {
+	page := "<html><head></head><body>" + strings.Repeat("a", 64) + "</body></html>"
+
+	config := Config{App: "sonarr", Theme: "nord"}
+	config.setDefaults()
+
+	themed := strings.Replace(page, "</body>", config.getReplacementString(), 1)
+
+	tests := []struct {
+		desc             string
+		acceptEncoding   string
+		serviceEncoding  string
+		streaming        bool
+		maxBodySize      int64
+		minSize          int64
+		contentLength    bool
+		expectedEncoding string
+		expected         string
+	}{
+		{
+			desc:             "should use the encoding with the highest quality",
+			acceptEncoding:   "gzip;q=0.8, br",
+			expectedEncoding: compressutil.Brotli,
+			expected:         themed,
+		},
+		{
+			desc:             "should keep the order of encodings with the same quality",
+			acceptEncoding:   "deflate, gzip",
+			expectedEncoding: compressutil.Deflate,
+			expected:         themed,
+		},
+		{
+			desc:             "should skip encodings the client refuses",
+			acceptEncoding:   "gzip; q=0, deflate",
+			expectedEncoding: compressutil.Deflate,
+			expected:         themed,
+		},
+		{
+			desc:     "should send uncompressed bodies without a supported encoding",
+			expected: themed,
+		},
+		{
+			desc:             "should encode streamed bodies",
+			acceptEncoding:   "br",
+			streaming:        true,
+			expectedEncoding: compressutil.Brotli,
+			expected:         themed,
+		},
+		{
+			desc:             "should encode bodies passed through",
+			acceptEncoding:   "gzip",
+			maxBodySize:      32,
+			expectedEncoding: compressutil.Gzip,
+			expected:         page,
+		},
+		{
+			desc:           "should send bodies smaller than minSize uncompressed",
+			acceptEncoding: "gzip",
+			minSize:        4096,
+			expected:       themed,
+		},
+		{
+			desc:             "should encode bodies reaching minSize",
+			acceptEncoding:   "gzip",
+			minSize:          int64(len(themed)),
+			expectedEncoding: compressutil.Gzip,
+			expected:         themed,
+		},
+		{
+			desc:           "should send streamed bodies announced smaller than minSize uncompressed",
+			acceptEncoding: "gzip",
+			streaming:      true,
+			minSize:        4096,
+			contentLength:  true,
+			expected:       themed,
+		},
+		{
+			desc:             "should encode streamed bodies of unknown size",
+			acceptEncoding:   "gzip",
+			streaming:        true,
+			minSize:          4096,
+			expectedEncoding: compressutil.Gzip,
+			expected:         themed,
+		},
+		{
+			desc:             "should keep the encoding of a service ignoring the request regardless of minSize",
+			acceptEncoding:   "gzip",
+			serviceEncoding:  compressutil.Gzip,
+			minSize:          4096,
+			expectedEncoding: compressutil.Gzip,
+			expected:         themed,
+		},
+		{
+			desc:             "should keep the encoding of a service ignoring the request",
+			acceptEncoding:   "br",
+			serviceEncoding:  compressutil.Gzip,
+			expectedEncoding: compressutil.Gzip,
+			expected:         themed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				if encoding := req.Header.Get("Accept-Encoding"); encoding != compressutil.Identity {
+					t.Errorf("service got Accept-Encoding: %q", encoding)
+				}
+
+				responseWriter.Header().Set("Content-Type", "text/html")
+				responseWriter.Header().Set("Content-Encoding", test.serviceEncoding)
+
+				if test.contentLength {
+					responseWriter.Header().Set("Content-Length", strconv.Itoa(len(page)))
+				}
+
+				_, _ = fmt.Fprint(responseWriter, compressString(page, test.serviceEncoding))
+			}
+
+			config := Config{
+				App:         "sonarr",
+				Theme:       "nord",
+				Streaming:   test.streaming,
+				MaxBodySize: test.maxBodySize,
+				Compression: handler.Compression{Negotiate: true, Level: 1, MinSize: test.minSize},
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept", "text/html")
+			req.Header.Set("Accept-Encoding", test.acceptEncoding)
+
+			handler.ServeHTTP(recorder, req)
+
+			encoding := recorder.Header().Get("Content-Encoding")
+			if encoding != test.expectedEncoding {
+				t.Errorf("got Content-Encoding: %q\n wanted: %q", encoding, test.expectedEncoding)
+			}
+
+			if test.serviceEncoding == "" && recorder.Header().Get("Vary") != "Accept-Encoding" {
+				t.Errorf("got Vary: %q", recorder.Header().Get("Vary"))
+			}
+
+			body, err := compressutil.Decode(recorder.Body, encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(body) != test.expected {
+				t.Errorf("got body: %s\n wanted: %s", body, test.expected)
+			}
+		})
+	}
+}
+
+func TestCompressionNegotiateUnthemed(t *testing.T) {
+	page := "<html><head></head><body>" + strings.Repeat("a", 64) + "</body></html>"
+
+	tests := []struct {
+		desc             string
+		statusCode       int
+		contentType      string
+		streaming        bool
+		minSize          int64
+		expectedEncoding string
+	}{
+		{
+			desc:             "should encode responses with excluded status codes",
+			statusCode:       http.StatusNotFound,
+			contentType:      "text/html",
+			expectedEncoding: compressutil.Gzip,
+		},
+		{
+			desc:             "should encode streamed responses with excluded status codes",
+			statusCode:       http.StatusNotFound,
+			contentType:      "text/html",
+			streaming:        true,
+			expectedEncoding: compressutil.Gzip,
+		},
+		{
+			desc:             "should encode responses with unsupported types",
+			statusCode:       http.StatusOK,
+			contentType:      "text/plain",
+			expectedEncoding: compressutil.Gzip,
+		},
+		{
+			desc:        "should send responses smaller than minSize uncompressed",
+			statusCode:  http.StatusNotFound,
+			contentType: "text/html",
+			minSize:     4096,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", test.contentType)
+				responseWriter.WriteHeader(test.statusCode)
+
+				_, _ = fmt.Fprint(responseWriter, page)
+			}
+
+			config := Config{
+				App:         "sonarr",
+				Theme:       "nord",
+				StatusCodes: "200-299",
+				Streaming:   test.streaming,
+				Compression: handler.Compression{Negotiate: true, MinSize: test.minSize},
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept", "text/html")
+			req.Header.Set("Accept-Encoding", "gzip")
+
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != test.statusCode {
+				t.Errorf("got status: %d\n wanted: %d", recorder.Code, test.statusCode)
+			}
+
+			encoding := recorder.Header().Get("Content-Encoding")
+			if encoding != test.expectedEncoding {
+				t.Errorf("got Content-Encoding: %q\n wanted: %q", encoding, test.expectedEncoding)
+			}
+
+			body, err := compressutil.Decode(recorder.Body, encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(body) != page {
+				t.Errorf("got body: %s\n wanted: %s", body, page)
+			}
+		})
+	}
+}
+
+func TestETag(t *testing.T) {
+	page := "<html><head></head><body></body></html>"
+
+	tests := []struct {
+		desc              string
+		contentType       string
+		ifNoneMatch       string
+		derived           bool
+		ignoreConditional bool
+		streaming         bool
+		expectedUpstream  string
+		expectedStatus    int
+	}{
+		{
+			desc:           "should derive the ETag of themed pages",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			desc:             "should translate derived ETags for the service",
+			derived:          true,
+			expectedUpstream: `"abc", W/"abc"`,
+			expectedStatus:   http.StatusNotModified,
+		},
+		{
+			desc:              "should answer not modified when the service ignores If-None-Match",
+			derived:           true,
+			ignoreConditional: true,
+			expectedUpstream:  `"abc", W/"abc"`,
+			expectedStatus:    http.StatusNotModified,
+		},
+		{
+			desc:              "should answer not modified for streamed pages",
+			derived:           true,
+			ignoreConditional: true,
+			streaming:         true,
+			expectedUpstream:  `"abc", W/"abc"`,
+			expectedStatus:    http.StatusNotModified,
+		},
+		{
+			desc:           "should drop ETags derived for another theme",
+			ifNoneMatch:    `W/"abc-tpother", "unrelated"`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			desc:           "should keep the ETag of pages that are not themed",
+			contentType:    "application/json",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			upstreamIfNoneMatch, upstreamIfModifiedSince := "", ""
+
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				upstreamIfNoneMatch = req.Header.Get("If-None-Match")
+				upstreamIfModifiedSince = req.Header.Get("If-Modified-Since")
+
+				contentType := test.contentType
+				if contentType == "" {
+					contentType = "text/html"
+				}
+
+				responseWriter.Header().Set("Content-Type", contentType)
+				responseWriter.Header().Set("ETag", `"abc"`)
+
+				if !test.ignoreConditional && strings.Contains(upstreamIfNoneMatch, `"abc"`) {
+					responseWriter.WriteHeader(http.StatusNotModified)
+
+					return
+				}
+
+				_, _ = fmt.Fprint(responseWriter, page)
+			}
+
+			config := Config{App: "sonarr", Theme: "nord", Streaming: test.streaming}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			serve := func(ifNoneMatch string) *httptest.ResponseRecorder {
+				recorder := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.Header.Set("Accept", "text/html")
+				req.Header.Set("If-Modified-Since", "Mon, 01 Aug 2022 00:00:00 GMT")
+
+				if ifNoneMatch != "" {
+					req.Header.Set("If-None-Match", ifNoneMatch)
+				}
+
+				handler.ServeHTTP(recorder, req)
+
+				return recorder
+			}
+
+			derived := serve("").Header().Get("ETag")
+			if test.contentType == "" && (!strings.HasPrefix(derived, `W/"abc-tp`) || derived == `W/"abc-tp"`) {
+				t.Fatalf("got derived ETag: %s", derived)
+			}
+
+			ifNoneMatch := test.ifNoneMatch
+			if test.derived {
+				ifNoneMatch = `"unrelated", ` + derived
+			}
+
+			recorder := serve(ifNoneMatch)
+
+			if recorder.Code != test.expectedStatus {
+				t.Errorf("got status: %d\n wanted: %d", recorder.Code, test.expectedStatus)
+			}
+
+			if upstreamIfNoneMatch != test.expectedUpstream {
+				t.Errorf("service got If-None-Match: %s\n wanted: %s", upstreamIfNoneMatch, test.expectedUpstream)
+			}
+
+			// If-None-Match takes precedence so dates must not make the service skip a change of theme.
+			if ifNoneMatch != "" && test.contentType == "" && upstreamIfModifiedSince != "" {
+				t.Errorf("service got If-Modified-Since: %s", upstreamIfModifiedSince)
+			}
+
+			if etag := recorder.Header().Get("ETag"); etag != derived {
+				t.Errorf("got ETag: %s\n wanted: %s", etag, derived)
+			}
+
+			if test.expectedStatus == http.StatusNotModified && recorder.Body.Len() != 0 {
+				t.Errorf("got body for not modified response: %s", recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestETagVariantLazy(t *testing.T) {
+	tests := []struct {
+		desc        string
+		etag        string
+		ifNoneMatch string
+		expected    int
+	}{
+		{
+			desc:     "should not compute the variant without an ETag",
+			expected: 0,
+		},
+		{
+			desc:     "should compute the variant to derive the ETag",
+			etag:     `"abc"`,
+			expected: 1,
+		},
+		{
+			desc:        "should compute the variant once for If-None-Match and the ETag",
+			etag:        `"abc"`,
+			ifNoneMatch: `W/"abc-tpvariant"`,
+			expected:    1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			computed := 0
+
+			next := func(responseWriter http.ResponseWriter, _ *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/html")
+
+				if test.etag != "" {
+					responseWriter.Header().Set("ETag", test.etag)
+				}
+
+				_, _ = fmt.Fprint(responseWriter, "<html><head></head><body></body></html>")
+			}
+
+			rewriteHandler, err := handler.New(
+				context.Background(),
+				http.HandlerFunc(next),
+				&handler.Config{},
+				"themepark",
+				handler.WithRewriter(func(_ *http.Request, body []byte) []byte { return body }),
+				handler.WithVariant(func(*http.Request) string {
+					computed++
+
+					return "variant"
+				}),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept", "text/html")
+
+			if test.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", test.ifNoneMatch)
+			}
+
+			rewriteHandler.ServeHTTP(recorder, req)
+
+			if computed != test.expected {
+				t.Errorf("variant computed %d times, wanted %d", computed, test.expected)
+			}
+		})
+	}
+}
+
+func TestHead(t *testing.T) {
+	page := "<html><head></head><body>" + strings.Repeat("<div>theme.park</div>", 10) + "</body></html>"
+
+	tests := []struct {
+		desc        string
+		contentType string
+		encoding    string
+		compression handler.Compression
+		etag        string
+		expectedGet int
+	}{
+		{
+			desc:        "should measure identity bodies",
+			expectedGet: 1,
+		},
+		{
+			desc:        "should measure compressed bodies",
+			encoding:    compressutil.Gzip,
+			expectedGet: 1,
+		},
+		{
+			desc:        "should measure negotiated bodies",
+			compression: handler.Compression{Negotiate: true},
+			expectedGet: 1,
+		},
+		{
+			desc:        "should reuse the length while the ETag is unchanged",
+			encoding:    compressutil.Brotli,
+			etag:        `"abc"`,
+			expectedGet: 0,
+		},
+		{
+			desc:        "should pass through pages that are not themed",
+			contentType: "application/json",
+			expectedGet: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			contentType := test.contentType
+			if contentType == "" {
+				contentType = "text/html"
+			}
+
+			body := compressString(page, test.encoding)
+			getCount := 0
+
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", contentType)
+				responseWriter.Header().Set("Content-Encoding", test.encoding)
+				responseWriter.Header().Set("Content-Length", strconv.Itoa(len(body)))
+
+				if test.etag != "" {
+					responseWriter.Header().Set("ETag", test.etag)
+				}
+
+				if req.Method == http.MethodGet {
+					getCount++
+
+					_, _ = fmt.Fprint(responseWriter, body)
+				}
+			}
+
+			config := Config{App: "sonarr", Theme: "nord", Head: true, Compression: test.compression}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			serve := func(method string) *httptest.ResponseRecorder {
+				recorder := httptest.NewRecorder()
+				req := httptest.NewRequest(method, "/", nil)
+				req.Header.Set("Accept", "text/html")
+				req.Header.Set("Accept-Encoding", "br, gzip")
+
+				handler.ServeHTTP(recorder, req)
+
+				return recorder
+			}
+
+			get := serve(http.MethodGet)
+
+			expectedLength := strconv.Itoa(get.Body.Len())
+			if test.contentType != "" {
+				expectedLength = strconv.Itoa(len(body))
+			}
+
+			// Requests made so far only warm up the cache.
+			getCount = 0
+
+			if test.etag != "" {
+				serve(http.MethodHead)
+
+				getCount = 0
+			}
+
+			head := serve(http.MethodHead)
+
+			if head.Code != http.StatusOK || head.Body.Len() != 0 {
+				t.Errorf("got status %d with body: %s", head.Code, head.Body.String())
+			}
+
+			if length := head.Header().Get("Content-Length"); length != expectedLength {
+				t.Errorf("got Content-Length: %s\n wanted: %s", length, expectedLength)
+			}
+
+			encoding := get.Header().Get("Content-Encoding")
+			if head.Header().Get("Content-Encoding") != encoding {
+				t.Errorf("got Content-Encoding: %s\n wanted: %s", head.Header().Get("Content-Encoding"), encoding)
+			}
+
+			if getCount != test.expectedGet {
+				t.Errorf("service got %d GET requests, wanted: %d", getCount, test.expectedGet)
+			}
+		})
+	}
+}
+
+func TestHeadDefault(t *testing.T) {
+	page := "<html><head></head><body></body></html>"
+
+	tests := []struct {
+		desc        string
+		contentType string
+		expLength   string
+		expETag     string
+	}{
+		{
+			desc:        "should remove the length and ETag of themed pages",
+			contentType: "text/html",
+		},
+		{
+			desc:        "should keep the headers of pages that are not themed",
+			contentType: "application/json",
+			expLength:   strconv.Itoa(len(page)),
+			expETag:     `"abc"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				if req.Method != http.MethodHead {
+					t.Errorf("service got %s request", req.Method)
+				}
+
+				responseWriter.Header().Set("Content-Type", test.contentType)
+				responseWriter.Header().Set("Content-Length", strconv.Itoa(len(page)))
+				responseWriter.Header().Set("ETag", `"abc"`)
+				responseWriter.Header().Set("Cache-Control", "no-cache")
+			}
+
+			config := Config{App: "sonarr", Theme: "nord"}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodHead, "/", nil)
+			req.Header.Set("Accept", "text/html")
+
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != http.StatusOK || recorder.Body.Len() != 0 {
+				t.Errorf("got status %d with body: %s", recorder.Code, recorder.Body.String())
+			}
+
+			if length := recorder.Header().Get("Content-Length"); length != test.expLength {
+				t.Errorf("got Content-Length: '%s' | expected: '%s'", length, test.expLength)
+			}
+
+			if etag := recorder.Header().Get("ETag"); etag != test.expETag {
+				t.Errorf("got ETag: '%s' | expected: '%s'", etag, test.expETag)
+			}
+
+			if recorder.Header().Get("Cache-Control") != "no-cache" {
+				t.Errorf("got headers without the ones of the service: %v", recorder.Header())
+			}
+		})
+	}
+}
+
+func TestHeadCookies(t *testing.T) {
+	page := "<html><head></head><body></body></html>"
+	headCount := 0
+	getCount := 0
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.Header().Set("Content-Type", "text/html")
+		responseWriter.Header().Set("ETag", `"abc"`)
+
+		if req.Method == http.MethodHead {
+			headCount++
+			responseWriter.Header().Set("Set-Cookie", fmt.Sprintf("session=head-%d", headCount))
+
+			return
+		}
+
+		getCount++
+		responseWriter.Header().Set("Set-Cookie", "session=get")
+		_, _ = fmt.Fprint(responseWriter, page)
+	}
+
+	config := Config{App: "sonarr", Theme: "nord", Head: true}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for client := 1; client <= 2; client++ {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodHead, "/", nil)
+		req.Header.Set("Accept", "text/html")
+
+		handler.ServeHTTP(recorder, req)
+
+		expected := fmt.Sprintf("session=head-%d", client)
+		if cookies := recorder.Header().Values("Set-Cookie"); len(cookies) != 1 || cookies[0] != expected {
+			t.Errorf("client %d got Set-Cookie: %q\n wanted: %s", client, cookies, expected)
+		}
+
+		if recorder.Header().Get("Content-Length") == "" || recorder.Header().Get("ETag") == `"abc"` {
+			t.Errorf("client %d got headers of the service: %v", client, recorder.Header())
+		}
+	}
+
+	if getCount != 1 {
+		t.Errorf("service got %d GET requests, wanted: 1", getCount)
+	}
+}
+
+func TestRange(t *testing.T) {
+	page := "<html><head></head><body>" + strings.Repeat("<div>theme.park</div>", 10) + "</body></html>"
+
+	config := Config{App: "sonarr", Theme: "nord"}
+	config.setDefaults()
+
+	themed := strings.Replace(page, "</body>", config.getReplacementString(), 1)
+
+	tests := []struct {
+		desc      string
+		header    map[string]string
+		streaming bool
+	}{
+		{
+			desc:   "should send the whole themed page for a range",
+			header: map[string]string{"Range": "bytes=10-20"},
+		},
+		{
+			desc:      "should send the whole themed page for a range when streaming",
+			header:    map[string]string{"Range": "bytes=-5"},
+			streaming: true,
+		},
+		{
+			desc:   "should send the whole themed page for a conditional range",
+			header: map[string]string{"Range": "bytes=0-5", "If-Range": `"abc"`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				if req.Header.Get("Range") != "" || req.Header.Get("If-Range") != "" {
+					t.Errorf("service got range: %s", req.Header.Get("Range"))
+				}
+
+				responseWriter.Header().Set("Content-Type", "text/html")
+				responseWriter.Header().Set("ETag", `"abc"`)
+
+				// ServeContent answers ranges with partial content and advertises range support.
+				http.ServeContent(responseWriter, req, "index.html", time.Time{}, strings.NewReader(page))
+			}
+
+			config := Config{App: "sonarr", Theme: "nord", Streaming: test.streaming}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept", "text/html")
+
+			for name, value := range test.header {
+				req.Header.Set(name, value)
+			}
+
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != http.StatusOK {
+				t.Errorf("got status: %d", recorder.Code)
+			}
+
+			for _, name := range []string{"Content-Range", "Accept-Ranges"} {
+				if value := recorder.Header().Get(name); value != "" {
+					t.Errorf("got %s: %s", name, value)
+				}
+			}
+
+			if recorder.Body.String() != themed {
+				t.Errorf("got body: %s\n wanted: %s", recorder.Body.String(), themed)
+			}
+		})
+	}
+}
+
+// informationalRecorder records informational responses separately like a server sending them ahead.
+// The recorder is not embedded since Yaegi can not embed pointers to types with methods next to other fields.
+type informationalRecorder struct {
+	recorder      *httptest.ResponseRecorder
+	informational []int
+}
+
+func (recorder *informationalRecorder) Header() http.Header {
+	return recorder.recorder.Header()
+}
+
+func (recorder *informationalRecorder) Write(data []byte) (int, error) {
+	return recorder.recorder.Write(data)
+}
+
+func (recorder *informationalRecorder) WriteHeader(statusCode int) {
+	if statusCode < http.StatusOK {
+		recorder.informational = append(recorder.informational, statusCode)
+
+		return
+	}
+
+	recorder.recorder.WriteHeader(statusCode)
+}
+
+func (recorder *informationalRecorder) Flush() {
+	recorder.recorder.Flush()
+}
+
+func TestStatusCodes(t *testing.T) {
+	page := "<html><head></head><body></body></html>"
+
+	config := Config{App: "sonarr", Theme: "nord"}
+	config.setDefaults()
+
+	themed := strings.Replace(page, "</body>", config.getReplacementString(), 1)
+
+	tests := []struct {
+		desc        string
+		statusCodes string
+		statusCode  int
+		streaming   bool
+		expected    string
+	}{
+		{
+			desc:       "should theme error pages by default",
+			statusCode: http.StatusInternalServerError,
+			expected:   themed,
+		},
+		{
+			desc:        "should theme listed status codes",
+			statusCodes: "200-299, 404",
+			statusCode:  http.StatusNotFound,
+			expected:    themed,
+		},
+		{
+			desc:        "should theme status codes in listed ranges",
+			statusCodes: "200-299,404",
+			statusCode:  http.StatusAccepted,
+			expected:    themed,
+		},
+		{
+			desc:        "should pass through other status codes",
+			statusCodes: "200-299,404",
+			statusCode:  http.StatusInternalServerError,
+			expected:    page,
+		},
+		{
+			desc:        "should pass through other status codes when streaming",
+			statusCodes: "200-299",
+			statusCode:  http.StatusMovedPermanently,
+			streaming:   true,
+			expected:    page,
+		},
+		{
+			desc:       "should pass through no content responses",
+			statusCode: http.StatusNoContent,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, _ *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/html")
+				responseWriter.WriteHeader(http.StatusEarlyHints)
+				responseWriter.WriteHeader(test.statusCode)
+
+				if test.statusCode != http.StatusNoContent {
+					_, _ = fmt.Fprint(responseWriter, page)
+				}
+			}
+
+			config := Config{App: "sonarr", Theme: "nord", StatusCodes: test.statusCodes, Streaming: test.streaming}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			informational := &informationalRecorder{recorder: recorder}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept", "text/html")
+
+			handler.ServeHTTP(informational, req)
+
+			if len(informational.informational) != 1 || informational.informational[0] != http.StatusEarlyHints {
+				t.Errorf("got informational responses: %v", informational.informational)
+			}
+
+			if recorder.Code != test.statusCode {
+				t.Errorf("got status: %d\n wanted: %d", recorder.Code, test.statusCode)
+			}
+
+			if recorder.Body.String() != test.expected {
+				t.Errorf("got body: %s\n wanted: %s", recorder.Body.String(), test.expected)
+			}
+		})
+	}
+}
+
+func TestStatusCodesValidation(t *testing.T) {
+	for _, statusCodes := range []string{"2xx", "200-", "299-200", "600", "200,,404"} {
+		config := Config{App: "sonarr", Theme: "nord", StatusCodes: statusCodes}
+		next := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+		if _, err := New(context.Background(), next, &config, "themepark"); err == nil {
+			t.Errorf("statusCodes %q should return an error", statusCodes)
+		}
+	}
+}
+
+func TestPaths(t *testing.T) {
+	page := "<html><head></head><body></body></html>"
+
+	config := Config{App: "sonarr", Theme: "nord"}
+	config.setDefaults()
+
+	themed := strings.Replace(page, "</body>", config.getReplacementString(), 1)
+
+	tests := []struct {
+		desc         string
+		includePaths []string
+		excludePaths []string
+		path         string
+		expected     string
+	}{
+		{
+			desc:         "should theme included paths",
+			includePaths: []string{"/app/**"},
+			path:         "/app/series/1",
+			expected:     themed,
+		},
+		{
+			desc:         "should pass through paths that are not included",
+			includePaths: []string{"/app/**"},
+			path:         "/other",
+			expected:     page,
+		},
+		{
+			desc:         "should pass through excluded paths",
+			excludePaths: []string{"/api/*"},
+			path:         "/api/docs",
+			expected:     page,
+		},
+		{
+			desc:         "should limit single stars to a path segment",
+			excludePaths: []string{"/api/*"},
+			path:         "/api/docs/index.html",
+			expected:     themed,
+		},
+		{
+			desc:         "should match single characters",
+			excludePaths: []string{"/v?/docs"},
+			path:         "/v2/docs",
+			expected:     page,
+		},
+		{
+			desc:         "should pass through paths matching a regular expression",
+			excludePaths: []string{"regex:^/(login|logout)"},
+			path:         "/login/sso?redirect=/",
+			expected:     page,
+		},
+		{
+			desc:         "should exclude paths that are also included",
+			includePaths: []string{"/**"},
+			excludePaths: []string{"/embed/**"},
+			path:         "/embed/player",
+			expected:     page,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				// Requests that are passed through reach the service untouched.
+				passthrough := req.Header.Get("Accept-Encoding") == "compress"
+				if passthrough != (test.expected == page) {
+					t.Errorf("service got Accept-Encoding: %q", req.Header.Get("Accept-Encoding"))
+				}
+
+				responseWriter.Header().Set("Content-Type", "text/html")
+				_, _ = fmt.Fprint(responseWriter, page)
+			}
+
+			config := Config{
+				App:          "sonarr",
+				Theme:        "nord",
+				IncludePaths: test.includePaths,
+				ExcludePaths: test.excludePaths,
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, test.path, nil)
+			req.Header.Set("Accept", "text/html")
+			req.Header.Set("Accept-Encoding", "compress")
+
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Body.String() != test.expected {
+				t.Errorf("got body: %s\n wanted: %s", recorder.Body.String(), test.expected)
+			}
+		})
+	}
+
+	config = Config{App: "sonarr", Theme: "nord", ExcludePaths: []string{"regex:/api/("}}
+	next := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+	if _, err := New(context.Background(), next, &config, "themepark"); err == nil {
+		t.Error("invalid regular expression should return an error")
+	}
+}
+
+func TestUnsupportedAcceptEncoding(t *testing.T) {
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		if encoding := strings.TrimSpace(req.Header.Get("Accept-Encoding")); encoding != "br, gzip" {
+			t.Errorf("service got Accept-Encoding: %q | expected: 'br, gzip'", encoding)
+		}
+
+		responseWriter.Header().Set("Content-Type", "text/html")
+	}
+
+	config := Config{App: "sonarr", Theme: "nord"}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("Accept-Encoding", "zstd, br, gzip")
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestAccept(t *testing.T) {
+	page := "<html><head></head><body></body></html>"
+
+	config := Config{App: "sonarr", Theme: "nord"}
+	config.setDefaults()
+
+	themed := strings.Replace(page, "</body>", config.getReplacementString(), 1)
+
+	tests := []struct {
+		desc           string
+		accept         []string
+		acceptWildcard bool
+		expected       string
+	}{
+		{
+			desc: "should theme Chrome navigations",
+			accept: []string{
+				"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8," +
+					"application/signed-exchange;v=b3;q=0.7",
+			},
+			expected: themed,
+		},
+		{
+			desc:     "should theme Firefox navigations",
+			accept:   []string{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+			expected: themed,
+		},
+		{
+			desc:     "should not theme browser image requests",
+			accept:   []string{"image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8"},
+			expected: page,
+		},
+		{
+			desc:     "should not theme curl requests",
+			accept:   []string{"*/*"},
+			expected: page,
+		},
+		{
+			desc:           "should theme curl requests accepting wildcards",
+			accept:         []string{"*/*"},
+			acceptWildcard: true,
+			expected:       themed,
+		},
+		{
+			desc:           "should not theme fetch() requests for other types",
+			accept:         []string{"application/json"},
+			acceptWildcard: true,
+			expected:       page,
+		},
+		{
+			desc:     "should not theme requests without an Accept header",
+			expected: page,
+		},
+		{
+			desc:     "should treat an empty Accept header like a missing one",
+			accept:   []string{" "},
+			expected: page,
+		},
+		{
+			desc:           "should theme requests without an Accept header accepting wildcards",
+			acceptWildcard: true,
+			expected:       themed,
+		},
+		{
+			desc:           "should not theme types refused with a quality of 0",
+			accept:         []string{"text/html;q=0, */*"},
+			acceptWildcard: true,
+			expected:       page,
+		},
+		{
+			desc:     "should theme types preferred over wildcards",
+			accept:   []string{"*/*;q=0, text/html;q=0.5"},
+			expected: themed,
+		},
+		{
+			desc:     "should theme subtype wildcards",
+			accept:   []string{"text/*"},
+			expected: themed,
+		},
+		{
+			desc:     "should ignore the case of media types",
+			accept:   []string{"Text/HTML"},
+			expected: themed,
+		},
+		{
+			desc:     "should not theme media types only containing monitored types",
+			accept:   []string{"text/html-sandboxed, application/xhtml+xml"},
+			expected: page,
+		},
+		{
+			desc:     "should ignore media ranges with parameters the monitored type does not have",
+			accept:   []string{"text/html;level=1;q=0, text/html"},
+			expected: themed,
+		},
+		{
+			desc:     "should not theme types only accepted with parameters they do not have",
+			accept:   []string{"text/html;level=1, application/json"},
+			expected: page,
+		},
+		{
+			desc:     "should combine Accept headers",
+			accept:   []string{"application/json", "text/html"},
+			expected: themed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, _ *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/html")
+				_, _ = fmt.Fprint(responseWriter, page)
+			}
+
+			config := Config{App: "sonarr", Theme: "nord", AcceptWildcard: test.acceptWildcard}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			for _, accept := range test.accept {
+				req.Header.Add("Accept", accept)
+			}
+
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Body.String() != test.expected {
+				t.Errorf("got body: %s\n wanted: %s", recorder.Body.String(), test.expected)
+			}
+		})
+	}
+}
+
+func TestConflictMode(t *testing.T) {
+	existing := "<link rel=\"stylesheet\" href=\"https://theme-park.dev/css/base/sonarr/dracula.css\">" +
+		"<style>@import url(\"https://theme-park.dev/css/addons/sonarr/sonarr-4k-logo/sonarr-4k-logo.css\");</style>"
+	page := "<head>" + existing + "<link rel=\"stylesheet\" href=\"/app.css\"></head><body></body>"
+	// The app's own stylesheets and text that only looks like a reference are never touched.
+	unrelated := "<head><link rel=\"stylesheet\" href=\"/static/css/base/main.css\">" +
+		"<link rel=stylesheet href=https://cdn.example.com/css/addons/menu.css>" +
+		"<!-- <link href=\"https://theme-park.dev/css/base/sonarr/dracula.css\"> -->" +
+		"<script>const theme = '<link href=\"https://theme-park.dev/css/base/sonarr/dracula.css\">';</script>" +
+		"</head><body></body>"
+	mirrored := "<link rel=stylesheet href=//theme.example.com/css/base/sonarr/dracula.css>"
+
+	config := Config{App: "sonarr", Theme: "nord"}
+	config.setDefaults()
+
+	stylesheets := config.getStylesheetString()
+
+	tests := []struct {
+		desc     string
+		mode     string
+		baseURL  string
+		body     string
+		expected string
+	}{
+		{
+			desc:     "append should be the default",
+			body:     page,
+			expected: "<head>" + existing + "<link rel=\"stylesheet\" href=\"/app.css\">" + stylesheets + "</head><body></body>",
+		},
+		{
+			desc:     "replace should remove existing references",
+			mode:     "replace",
+			body:     page,
+			expected: "<head><style></style><link rel=\"stylesheet\" href=\"/app.css\">" + stylesheets + "</head><body></body>",
+		},
+		{
+			desc:     "replace should not inject twice when chained",
+			mode:     "replace",
+			body:     "<head>" + stylesheets + "</head><body></body>",
+			expected: "<head>" + stylesheets + "</head><body></body>",
+		},
+		{
+			desc:     "replace should keep stylesheets that are not from theme.park",
+			mode:     "replace",
+			body:     unrelated,
+			expected: strings.Replace(unrelated, "</head>", stylesheets+"</head>", 1),
+		},
+		{
+			desc:     "replace should remove references to the configured baseUrl",
+			mode:     "replace",
+			baseURL:  "https://theme.example.com",
+			body:     "<head>" + mirrored + "</head><body></body>",
+			expected: "<head>" + strings.ReplaceAll(stylesheets, "https://theme-park.dev", "https://theme.example.com") + "</head><body></body>",
+		},
+		{
+			desc:     "skip should leave pages with references untouched",
+			mode:     "skip",
+			body:     page,
+			expected: page,
+		},
+		{
+			desc:     "skip should inject without references",
+			mode:     "skip",
+			body:     "<head></head><body></body>",
+			expected: "<head>" + stylesheets + "</head><body></body>",
+		},
+		{
+			desc:     "skip should inject into pages with stylesheets that are not from theme.park",
+			mode:     "skip",
+			body:     unrelated,
+			expected: strings.Replace(unrelated, "</head>", stylesheets+"</head>", 1),
+		},
+		{
+			desc:     "append should keep existing references",
+			mode:     "append",
+			body:     page,
+			expected: "<head>" + existing + "<link rel=\"stylesheet\" href=\"/app.css\">" + stylesheets + "</head><body></body>",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(responseWriter http.ResponseWriter, _ *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/html")
+				_, _ = fmt.Fprint(responseWriter, test.body)
+			}
+
+			config := Config{App: "sonarr", Theme: "nord", BaseURL: test.baseURL, Target: "</head>", ConflictMode: test.mode}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept", "text/html")
+
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Body.String() != test.expected {
+				t.Errorf("got body: %s\n wanted: %s", recorder.Body.String(), test.expected)
+			}
+		})
+	}
+
+	config = Config{App: "sonarr", ConflictMode: "replaces"}
+	if _, err := New(context.Background(), http.NotFoundHandler(), &config, "themepark"); err == nil {
+		t.Error("unknown conflictMode should return an error")
+	}
+}
+
+func TestValidation(t *testing.T) {
+	tests := []struct {
+		desc     string
+		config   Config
+		expected string
+	}{
+		{
+			desc:   "known app and theme should be valid",
+			config: Config{App: "sonarr", Theme: "nord"},
+		},
+		{
+			desc:   "known app with base theme and addons should be valid",
+			config: Config{App: "radarr", Theme: "base", Addons: []string{"darker", "radarr-4k-logo"}},
+		},
+		{
+			desc:     "unknown app should suggest close match",
+			config:   Config{App: "sonar", Theme: "nord"},
+			expected: `invalid theme.park configuration: unknown app "sonar" (did you mean "sonarr"?)`,
+		},
+		{
+			desc:     "app with wrong case should suggest lower case app",
+			config:   Config{App: "Sonarr", Theme: "nord"},
+			expected: `invalid theme.park configuration: unknown app "Sonarr" (did you mean "sonarr"?)`,
+		},
+		{
+			desc:     "unknown theme should suggest close matches",
+			config:   Config{App: "sonarr", Theme: "blackberry"},
+			expected: `invalid theme.park configuration: unknown theme "blackberry" (did you mean one of "blackberry-abyss", "blackberry-carol", "blackberry-royal"?)`,
+		},
+		{
+			desc:     "unknown theme without close match should not suggest",
+			config:   Config{App: "sonarr", Theme: "rainbow-unicorn"},
+			expected: `invalid theme.park configuration: unknown theme "rainbow-unicorn"`,
+		},
+		{
+			desc:     "unknown addon should suggest app addon",
+			config:   Config{App: "sonarr", Theme: "nord", Addons: []string{"4k-logos"}},
+			expected: `invalid theme.park configuration: unknown addon "4k-logos" for app "sonarr" (did you mean "sonarr-4k-logo"?)`,
+		},
+		{
+			desc:     "addon for app without addons should be invalid",
+			config:   Config{App: "plex", Theme: "nord", Addons: []string{"darker"}},
+			expected: `invalid theme.park configuration: app "plex" does not support addons but "darker" was requested`,
+		},
+		{
+			desc:   "auto app should accept addons of any app",
+			config: Config{App: "auto", Theme: "nord", Addons: []string{"darker"}},
+		},
+		{
+			desc:     "auto app should reject unknown addons",
+			config:   Config{App: "auto", Theme: "nord", Addons: []string{"darkest"}},
+			expected: `invalid theme.park configuration: unknown addon "darkest" for app "auto" (did you mean "darker"?)`,
+		},
+		{
+			desc:     "negative compression level should be invalid",
+			config:   Config{App: "sonarr", Theme: "nord", Compression: handler.Compression{Level: -1}},
+			expected: "invalid compression level -1 or minSize 0: values must not be negative",
+		},
+		{
+			desc:     "compression level above the highest brotli level should be invalid",
+			config:   Config{App: "sonarr", Theme: "nord", Compression: handler.Compression{Level: 12}},
+			expected: "invalid compression level 12: the highest level is 11",
+		},
+		{
+			desc:   "compression level only capped for some algorithms should be valid",
+			config: Config{App: "sonarr", Theme: "nord", Compression: handler.Compression{Level: 11}},
+		},
+		{
+			desc:     "compression minSize without negotiate should be invalid",
+			config:   Config{App: "sonarr", Theme: "nord", Compression: handler.Compression{MinSize: 1024}},
+			expected: "invalid compression minSize 1024: minSize requires negotiate",
+		},
+		{
+			desc:   "skipValidation should allow custom values",
+			config: Config{App: "my-app", Theme: "my-theme", Addons: []string{"my-addon"}, SkipValidation: true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := test.config
+			next := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+			_, err := New(context.Background(), next, &config, "themepark")
+
+			result := ""
+			if err != nil {
+				result = err.Error()
+			}
+
+			if test.expected != result {
+				t.Errorf("result: '%s' | expected: '%s'", result, test.expected)
+			}
+		})
+	}
+}
+
+func TestSwitcher(t *testing.T) {
+	tests := []struct {
+		desc     string
+		cookies  []*http.Cookie
+		expected Config
+		switcher Switcher
+	}{
+		{
+			desc:     "no cookie should use configured theme",
+			expected: Config{App: "sonarr", Theme: "dark"},
+		},
+		{
+			desc:     "cookie should select allowed theme",
+			cookies:  []*http.Cookie{{Name: "themepark_theme", Value: "nord"}},
+			expected: Config{App: "sonarr", Theme: "nord"},
+		},
+		{
+			desc:     "cookie with base theme should select app base theme",
+			cookies:  []*http.Cookie{{Name: "themepark_theme", Value: "base"}},
+			expected: Config{App: "sonarr", Theme: "sonarr-base"},
+		},
+		{
+			desc:     "cookie with theme outside allowlist should use configured theme",
+			cookies:  []*http.Cookie{{Name: "themepark_theme", Value: "nord"}},
+			switcher: Switcher{Themes: []string{"dracula"}},
+			expected: Config{App: "sonarr", Theme: "dark"},
+		},
+		{
+			desc: "cookie should select allowed addons",
+			cookies: []*http.Cookie{
+				{Name: "themepark_theme", Value: "base"},
+				{Name: "themepark_theme_addons", Value: "darker,sonarr-4k-logo,unknown"},
+			},
+			expected: Config{App: "sonarr", Theme: "sonarr-base", Addons: []string{"sonarr-darker", "sonarr-4k-logo"}},
+		},
+		{
+			desc:     "custom cookie name should be used",
+			cookies:  []*http.Cookie{{Name: "theme", Value: "nord"}},
+			switcher: Switcher{CookieName: "theme"},
+			expected: Config{App: "sonarr", Theme: "nord"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			switcher := test.switcher
+			switcher.Enabled = true
+			config := Config{App: "sonarr", Theme: "dark", Addons: []string{"4k-logo"}, Switcher: switcher}
+
+			next := func(responseWriter http.ResponseWriter, _ *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/html")
+				_, _ = fmt.Fprint(responseWriter, "<head></head><body></body>")
+			}
+
+			themePark, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept", "text/html")
+
+			for _, cookie := range test.cookies {
+				req.AddCookie(cookie)
+			}
+
+			themePark.ServeHTTP(recorder, req)
+
+			expected := test.expected
+			if expected.Addons == nil {
+				expected.Addons = []string{"4k-logo"}
+			}
+
+			expected.setDefaults()
+
+			expectedBody := "<head></head><body>" + expected.getReplacementString()
+			if recorder.Body.String() != expectedBody {
+				t.Errorf("got body: %s\n wanted: %s", recorder.Body.String(), expectedBody)
+			}
+
+			if recorder.Header().Get("Vary") != "Cookie" {
+				t.Errorf("got Vary: '%s' | expected: 'Cookie'", recorder.Header().Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestSwitcherDefaults(t *testing.T) {
+	addons := []string{"darker", "4k-logo"}
+	themes := make([]string, 1, 4)
+	themes[0] = "nord"
+
+	config := Config{App: "sonarr", Theme: "dark"}
+	switcher := Switcher{Enabled: true, Themes: themes, Addons: addons}
+	switcher.setDefaults(&config)
+
+	if result := strings.Join(switcher.Addons, ","); result != "sonarr-darker,sonarr-4k-logo" {
+		t.Errorf("got addons: '%s' | expected: 'sonarr-darker,sonarr-4k-logo'", result)
+	}
+
+	if result := strings.Join(addons, ","); result != "darker,4k-logo" {
+		t.Errorf("configured addons changed: '%s' | expected: 'darker,4k-logo'", result)
+	}
+
+	if extended := themes[:cap(themes)]; extended[1] != "" {
+		t.Errorf("configured themes changed: %v", extended)
+	}
+}
+
+func TestSwitcherSet(t *testing.T) {
+	tests := []struct {
+		desc        string
+		url         string
+		referer     string
+		expCode     int
+		expLocation string
+		expCookies  map[string]string
+	}{
+		{
+			desc:        "should set theme cookie and redirect to provided path",
+			url:         "/__themepark/set?theme=nord&redirect=/series",
+			expCode:     http.StatusFound,
+			expLocation: "/series",
+			expCookies:  map[string]string{"themepark_theme": "nord"},
+		},
+		{
+			desc:        "should set addons cookie and redirect to referer",
+			url:         "/__themepark/set?theme=base&addons=darker,4k-logo",
+			referer:     "http://example.com/movies?page=2",
+			expCode:     http.StatusFound,
+			expLocation: "/movies?page=2",
+			expCookies: map[string]string{
+				"themepark_theme":        "base",
+				"themepark_theme_addons": "sonarr-darker,sonarr-4k-logo",
+			},
+		},
+		{
+			desc:        "should not redirect to other hosts",
+			url:         "/__themepark/set?theme=nord&redirect=//evil.com/",
+			referer:     "http://evil.com/",
+			expCode:     http.StatusFound,
+			expLocation: "/",
+			expCookies:  map[string]string{"themepark_theme": "nord"},
+		},
+		{
+			desc:        "should not redirect to other hosts through control characters",
+			url:         "/__themepark/set?theme=nord&redirect=/%09/evil.com",
+			expCode:     http.StatusFound,
+			expLocation: "/",
+			expCookies:  map[string]string{"themepark_theme": "nord"},
+		},
+		{
+			desc:        "should not redirect to other hosts through backslashes",
+			url:         "/__themepark/set?theme=nord&redirect=/%5Cevil.com",
+			expCode:     http.StatusFound,
+			expLocation: "/",
+			expCookies:  map[string]string{"themepark_theme": "nord"},
+		},
+		{
+			desc:        "should clear theme cookie with empty theme",
+			url:         "/__themepark/set?theme=",
+			expCode:     http.StatusFound,
+			expLocation: "/",
+			expCookies:  map[string]string{"themepark_theme": ""},
+		},
+		{
+			desc:        "should keep theme cookie when only addons are set",
+			url:         "/__themepark/set?addons=darker",
+			expCode:     http.StatusFound,
+			expLocation: "/",
+			expCookies:  map[string]string{"themepark_theme_addons": "sonarr-darker"},
+		},
+		{
+			desc:       "should reject themes outside allowlist",
+			url:        "/__themepark/set?theme=unknown",
+			expCode:    http.StatusBadRequest,
+			expCookies: map[string]string{},
+		},
+		{
+			desc:       "should reject addons outside allowlist",
+			url:        "/__themepark/set?theme=nord&addons=unknown",
+			expCode:    http.StatusBadRequest,
+			expCookies: map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := Config{App: "sonarr", Theme: "dark", Switcher: Switcher{Enabled: true}}
+
+			next := func(http.ResponseWriter, *http.Request) {
+				t.Error("reserved path should not reach the service")
+			}
+
+			themePark, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://example.com"+test.url, nil)
+			req.Header.Set("Referer", test.referer)
+
+			themePark.ServeHTTP(recorder, req)
+
+			if recorder.Code != test.expCode {
+				t.Errorf("got status: %d | expected: %d", recorder.Code, test.expCode)
+			}
+
+			if location := recorder.Header().Get("Location"); location != test.expLocation {
+				t.Errorf("got location: '%s' | expected: '%s'", location, test.expLocation)
+			}
+
+			cookies := recorder.Result().Cookies()
+			if len(cookies) != len(test.expCookies) {
+				t.Errorf("got cookies: %v | expected: %v", cookies, test.expCookies)
+			}
+
+			for _, cookie := range cookies {
+				if expected, exists := test.expCookies[cookie.Name]; !exists || expected != cookie.Value {
+					t.Errorf("got cookie %s: '%s' | expected: '%s'", cookie.Name, cookie.Value, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestPicker(t *testing.T) {
+	config := Config{
+		App:      "radarr",
+		Theme:    "dark",
+		Switcher: Switcher{Picker: true, Themes: []string{"dark", "nord"}},
+	}
+
+	next := func(responseWriter http.ResponseWriter, _ *http.Request) {
+		responseWriter.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprint(responseWriter, "<head></head><body><div></div></body>")
+	}
+
+	themePark, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("should inject picker before end of body", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", "text/html")
+		req.AddCookie(&http.Cookie{Name: "themepark_theme", Value: "nord"})
+
+		themePark.ServeHTTP(recorder, req)
+
+		selected := Config{App: "radarr", Theme: "nord"}
+		selected.setDefaults()
+
+		expected := "<head></head><body><div></div>" +
+			strings.TrimSuffix(selected.getReplacementString(), "</body>") +
+			"<script src=\"/__themepark/picker.js\" data-themepark-picker data-app=\"radarr\" " +
+			"data-base-url=\"https://theme-park.dev\" data-cookie=\"themepark_theme\" data-theme=\"nord\" " +
+			"data-themes=\"dark,nord,radarr-base\" defer></script></body>"
+		if recorder.Body.String() != expected {
+			t.Errorf("got body: %s\n wanted: %s", recorder.Body.String(), expected)
+		}
+	})
+
+	t.Run("should serve picker script", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/__themepark/picker.js", nil)
+
+		themePark.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("got status: %d | expected: %d", recorder.Code, http.StatusOK)
+		}
+
+		if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/javascript") {
+			t.Errorf("got Content-Type: '%s' | expected: 'application/javascript'", contentType)
+		}
+
+		if recorder.Body.String() != pickerScript {
+			t.Error("picker script was not served")
+		}
+	})
+}
+
+func TestApps(t *testing.T) {
+	config := Config{
+		Apps: []AppRule{
+			{Host: "sonarr.example.com", App: "sonarr", Theme: "nord"},
+			{Host: "*.example.com", PathPrefix: "/radarr", App: "radarr", Theme: "dracula"},
+			{Headers: map[string]string{"X-App": "qbittorrent"}, App: "qbittorrent", Theme: "dark"},
+			{Host: "plex.example.com", App: "plex", Target: "</title>"},
+		},
+	}
+
+	next := func(responseWriter http.ResponseWriter, _ *http.Request) {
+		responseWriter.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprint(responseWriter, "<head><title></title></head><body></body>")
+	}
+
+	themePark, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc        string
+		url         string
+		headers     map[string]string
+		expected    Config
+		passthrough bool
+	}{
+		{
+			desc:     "should match host",
+			url:      "http://sonarr.example.com:8080/series",
+			expected: Config{App: "sonarr", Theme: "nord"},
+		},
+		{
+			desc:     "should match wildcard host and path prefix",
+			url:      "http://apps.example.com/radarr/movies",
+			expected: Config{App: "radarr", Theme: "dracula"},
+		},
+		{
+			desc:     "should match header",
+			url:      "http://torrent.local/",
+			headers:  map[string]string{"X-App": "qbittorrent"},
+			expected: Config{App: "qbittorrent", Theme: "dark"},
+		},
+		{
+			desc:     "should use rule target",
+			url:      "http://plex.example.com/web",
+			expected: Config{App: "plex", Target: "</title>"},
+		},
+		{
+			desc:        "should pass through without matching rule",
+			url:         "http://apps.example.com/lidarr",
+			passthrough: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, test.url, nil)
+			req.Header.Set("Accept", "text/html")
+
+			for name, value := range test.headers {
+				req.Header.Set(name, value)
+			}
+
+			themePark.ServeHTTP(recorder, req)
+
+			expectedBody := "<head><title></title></head><body></body>"
+
+			if !test.passthrough {
+				expected := test.expected
+				expected.setDefaults()
+
+				expectedBody = strings.Replace(expectedBody, expected.Target, expected.getReplacementString(), 1)
+			}
+
+			if recorder.Body.String() != expectedBody {
+				t.Errorf("got body: %s\n wanted: %s", recorder.Body.String(), expectedBody)
+			}
+		})
+	}
+}
+
+func TestAppsFallback(t *testing.T) {
+	config := Config{
+		App:   "sonarr",
+		Theme: "nord",
+		Apps:  []AppRule{{PathPrefix: "/radarr", App: "radarr", Theme: "dracula"}},
+	}
+
+	next := func(responseWriter http.ResponseWriter, _ *http.Request) {
+		responseWriter.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprint(responseWriter, "<body></body>")
+	}
+
+	themePark, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/series", nil)
+	req.Header.Set("Accept", "text/html")
+
+	themePark.ServeHTTP(recorder, req)
+
+	expected := Config{App: "sonarr", Theme: "nord"}
+	expected.setDefaults()
+
+	if recorder.Body.String() != "<body>"+expected.getReplacementString() {
+		t.Errorf("got body: %s\n wanted: %s", recorder.Body.String(), "<body>"+expected.getReplacementString())
+	}
+}
+
+func TestAppsValidation(t *testing.T) {
+	tests := []struct {
+		desc     string
+		config   Config
+		expected string
+	}{
+		{
+			desc:     "rule without app should be invalid",
+			config:   Config{Apps: []AppRule{{Host: "example.com"}}},
+			expected: "invalid theme.park configuration for apps[0]: app is required",
+		},
+		{
+			desc:     "rule with unknown theme should be invalid",
+			config:   Config{Apps: []AppRule{{App: "sonarr", Theme: "nord"}, {App: "radarr", Theme: "nrod"}}},
+			expected: `invalid theme.park configuration for apps[1]: unknown theme "nrod" (did you mean "nord"?)`,
+		},
+		{
+			desc:     "rule with unknown addon should be invalid",
+			config:   Config{Apps: []AppRule{{App: "sonarr", Theme: "nord", Addons: []string{"darkr"}}}},
+			expected: `invalid theme.park configuration for apps[0]: unknown addon "darkr" for app "sonarr" (did you mean "sonarr-darker"?)`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := test.config
+			next := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+			_, err := New(context.Background(), next, &config, "themepark")
+			if err == nil || err.Error() != test.expected {
+				t.Errorf("result: '%v' | expected: '%s'", err, test.expected)
+			}
+		})
+	}
+}
+
+func TestDetectApp(t *testing.T) {
+	tests := []struct {
+		desc     string
+		body     string
+		expected string
+	}{
+		{
+			desc:     "Sonarr should be detected from bootstrap script",
+			body:     "<head><title>Series - Home</title><script>window.Sonarr = {urlBase: ''};</script></head>",
+			expected: "sonarr",
+		},
+		{
+			desc:     "qBittorrent should be detected from title",
+			body:     "<head><title>qBittorrent v4.5.2 Web UI</title></head>",
+			expected: "qbittorrent",
+		},
+		{
+			desc:     "VueTorrent should not be shadowed by shorter names",
+			body:     "<head><title>VueTorrent</title></head>",
+			expected: "vuetorrent",
+		},
+		{
+			desc:     "Pi-hole should be detected ignoring punctuation",
+			body:     "<head><title>Pi-hole - raspberrypi</title></head>",
+			expected: "pihole",
+		},
+		{
+			desc:     "Jellyfin should be detected from meta tag",
+			body:     "<head><meta name=\"application-name\" content=\"Jellyfin\"><title></title></head>",
+			expected: "jellyfin",
+		},
+		{
+			desc:     "Uptime Kuma should be detected from og:site_name",
+			body:     "<head><meta property='og:site_name' content='Uptime Kuma'></head>",
+			expected: "uptime-kuma",
+		},
+		{
+			desc:     "app names inside other words should not be detected",
+			body:     "<head><title>Complex Zombie Assembly</title><meta name=\"application-name\" content=\"Combine\"></head>",
+			expected: "",
+		},
+		{
+			desc:     "app names inside other words should not shadow the app",
+			body:     "<head><title>Zombie Assembly - Plex</title></head>",
+			expected: "plex",
+		},
+		{
+			desc:     "unknown app should not be detected",
+			body:     "<head><title>My Homepage</title><meta name=\"description\" content=\"sonarr\"></head>",
+			expected: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if result := detectApp([]byte(test.body)); result != test.expected {
+				t.Errorf("result: '%s' | expected: '%s'", result, test.expected)
+			}
+		})
+	}
+}
+
+func TestAutoApp(t *testing.T) {
+	tests := []struct {
+		desc     string
+		body     string
+		expected string
+	}{
+		{
+			desc: "should inject detected app theme",
+			body: "<head><title>Radarr</title></head><body></body>",
+			expected: "<head><title>Radarr</title></head><body>" +
+				"<link rel=\"stylesheet\" type=\"text/css\" href=\"https://theme-park.dev/css/base/radarr/radarr-base.css\">" +
+				"<link rel=\"stylesheet\" type=\"text/css\" href=\"https://theme-park.dev/css/addons/radarr/radarr-darker/radarr-darker.css\">" +
+				"</body>",
+		},
+		{
+			desc: "should skip addons the detected app does not support",
+			body: "<head><title>Organizr</title></head><body></body>",
+			expected: "<head><title>Organizr</title>" +
+				"<link rel=\"stylesheet\" type=\"text/css\" href=\"https://theme-park.dev/css/base/organizr/organizr-base.css\">" +
+				"</head><body></body>",
+		},
+		{
+			desc:     "should not change unknown apps",
+			body:     "<head><title>Homepage</title></head><body></body>",
+			expected: "<head><title>Homepage</title></head><body></body>",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := Config{App: "auto", Theme: "base", Addons: []string{"darker"}}
+
+			next := func(responseWriter http.ResponseWriter, _ *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/html")
+				_, _ = fmt.Fprint(responseWriter, test.body)
+			}
+
+			themePark, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept", "text/html")
+
+			themePark.ServeHTTP(recorder, req)
+
+			if recorder.Body.String() != test.expected {
+				t.Errorf("got body: %s\n wanted: %s", recorder.Body.String(), test.expected)
+			}
+		})
+	}
+}
+
+func TestInline(t *testing.T) {
+	requests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/css/base/sonarr/nord.css", func(responseWriter http.ResponseWriter, _ *http.Request) {
+		requests++
+
+		_, _ = fmt.Fprint(responseWriter, "@import url(\"/css/theme-options/nord.css\");\n"+
+			"@import '../../defaults/print.css' print;\n"+
+			".logo{background:url(../../../img/logo.png)}")
+	})
+	mux.HandleFunc("/css/theme-options/nord.css", func(responseWriter http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(responseWriter, ":root{--main-bg-color:#2e3440}")
+	})
+	mux.HandleFunc("/css/defaults/print.css", func(responseWriter http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(responseWriter, "body{color:black}")
+	})
+	mux.HandleFunc("/css/addons/sonarr/sonarr-4k-logo/sonarr-4k-logo.css", func(responseWriter http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(responseWriter, ".logo{content:'$1'}")
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		desc     string
+		config   Config
+		expected string
+	}{
+		{
+			desc:   "should inline stylesheets with imports",
+			config: Config{App: "sonarr", Theme: "nord", BaseURL: server.URL, Inline: true, Addons: []string{"4k-logo"}},
+			expected: "<head></head><body>" +
+				"<style data-themepark=\"base\">:root{--main-bg-color:#2e3440}\n" +
+				"@media print {\nbody{color:black}\n}\n" +
+				".logo{background:url(\"" + server.URL + "/img/logo.png\")}</style>" +
+				"<style data-themepark=\"addon\">.logo{content:'$1'}</style>" +
+				"</body>",
+		},
+		{
+			desc:   "should fall back to links when stylesheets are unavailable",
+			config: Config{App: "sonarr", Theme: "dark", BaseURL: server.URL, Inline: true},
+			expected: "<head></head><body>" +
+				fmt.Sprintf(replFormat, server.URL, "sonarr", "dark") +
+				"</body>",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := test.config
+
+			next := func(responseWriter http.ResponseWriter, _ *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/html")
+				_, _ = fmt.Fprint(responseWriter, "<head></head><body></body>")
+			}
+
+			themePark, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The second request must be served from the cache.
+			for attempt := 0; attempt < 2; attempt++ {
+				recorder := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.Header.Set("Accept", "text/html")
+
+				themePark.ServeHTTP(recorder, req)
+
+				if recorder.Body.String() != test.expected {
+					t.Errorf("got body: %s\n wanted: %s", recorder.Body.String(), test.expected)
+				}
+			}
+		})
+	}
+
+	if requests != 1 {
+		t.Errorf("stylesheet should be cached but was requested %d times", requests)
+	}
+}
+
+func TestInlineFailures(t *testing.T) {
+	requests := 0
+	failing := true
+
+	server := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, _ *http.Request) {
+		requests++
+
+		if failing {
+			responseWriter.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		_, _ = fmt.Fprint(responseWriter, "body{}")
+	}))
+	defer server.Close()
+
+	cache, err := newStyleCache("1ns", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	href := server.URL + "/css/base/sonarr/nord.css"
+
+	// Failures are kept until the retry delay has passed rather than fetched for every request.
+	for attempt := 0; attempt < 2; attempt++ {
+		if _, err := cache.get(href, 0); err == nil {
+			t.Error("expected an error for an unavailable stylesheet")
+		}
+	}
+
+	if requests != 1 {
+		t.Errorf("failure should be cached but the stylesheet was requested %d times", requests)
+	}
+
+	failing = false
+
+	if css, err := cache.load(href, 0, nil); css != "body{}" || err != nil {
+		t.Fatalf("got: %q, %v\n wanted: %q", css, err, "body{}")
+	}
+
+	// The last good copy is served once the stylesheet is unavailable again, even though it expired.
+	failing = true
+
+	if css, err := cache.load(href, 0, nil); css != "body{}" || err != nil {
+		t.Errorf("got: %q, %v\n wanted: %q", css, err, "body{}")
+	}
+
+	if css, err := cache.get(href, 0); css != "body{}" || err != nil {
+		t.Errorf("got: %q, %v\n wanted: %q", css, err, "body{}")
+	}
+
+	if requests != 3 {
+		t.Errorf("stylesheet should be requested 3 times but was requested %d times", requests)
+	}
+}
+
+func TestInlineTTL(t *testing.T) {
+	config := Config{App: "sonarr", Inline: true, InlineTTL: "1 hour"}
+	next := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+	_, err := New(context.Background(), next, &config, "themepark")
+	if err == nil || !strings.Contains(err.Error(), `error parsing inlineTtl "1 hour"`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestAssets(t *testing.T) {
+	directory := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(directory, "css", "base", "sonarr"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	err := os.WriteFile(
+		filepath.Join(directory, "css", "base", "sonarr", "nord.css"),
+		[]byte("@import url(\"https://theme-park.dev/css/theme-options/nord.css\");"),
+		0o600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mirrored := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/css/theme-options/nord.css" {
+			http.NotFound(responseWriter, req)
+
+			return
+		}
+
+		mirrored++
+
+		_, _ = fmt.Fprintf(responseWriter, "@font-face{src:url(\"%s/fonts/nord.woff2\")}", "http://"+req.Host)
+	}))
+	defer upstream.Close()
+
+	config := Config{
+		App:     "sonarr",
+		Theme:   "nord",
+		BaseURL: upstream.URL,
+		Assets:  Assets{Directory: directory, Mirror: true},
+	}
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/__themepark/") {
+			t.Errorf("asset request %s should not reach the service", req.URL.Path)
+		}
+
+		responseWriter.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprint(responseWriter, "<head></head><body></body>")
+	}
+
+	themePark, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc           string
+		url            string
+		expCode        int
+		expContentType string
+		expBody        string
+	}{
+		{
+			desc:           "should inject links to the reserved path",
+			url:            "/",
+			expCode:        http.StatusOK,
+			expContentType: "text/html",
+			expBody:        "<head></head><body>" + fmt.Sprintf(replFormat, "/__themepark", "sonarr", "nord") + "</body>",
+		},
+		{
+			desc:           "should serve stylesheet from directory with rewritten references",
+			url:            "/__themepark/css/base/sonarr/nord.css",
+			expCode:        http.StatusOK,
+			expContentType: "text/css; charset=utf-8",
+			expBody:        "@import url(\"/__themepark/css/theme-options/nord.css\");",
+		},
+		{
+			desc:           "should mirror stylesheet missing from directory",
+			url:            "/__themepark/css/theme-options/nord.css",
+			expCode:        http.StatusOK,
+			expContentType: "text/css; charset=utf-8",
+			expBody:        "@font-face{src:url(\"/__themepark/fonts/nord.woff2\")}",
+		},
+		{
+			desc:    "should not escape the directory",
+			url:     "/__themepark/../../etc/passwd",
+			expCode: http.StatusNotFound,
+		},
+		{
+			desc:    "should return not found for unknown assets",
+			url:     "/__themepark/css/base/sonarr/unknown.css",
+			expCode: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, test.url, nil)
+			req.Header.Set("Accept", "text/html")
+
+			themePark.ServeHTTP(recorder, req)
+
+			if recorder.Code != test.expCode {
+				t.Errorf("got status: %d | expected: %d", recorder.Code, test.expCode)
+			}
+
+			if test.expCode != http.StatusOK {
+				return
+			}
+
+			if contentType := recorder.Header().Get("Content-Type"); contentType != test.expContentType {
+				t.Errorf("got Content-Type: '%s' | expected: '%s'", contentType, test.expContentType)
+			}
+
+			if recorder.Body.String() != test.expBody {
+				t.Errorf("got body: %s\n wanted: %s", recorder.Body.String(), test.expBody)
+			}
+		})
+	}
+
+	// Request the mirrored stylesheet again to ensure it is cached.
+	themePark.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/__themepark/css/theme-options/nord.css", nil))
+
+	if mirrored != 1 {
+		t.Errorf("mirrored stylesheet should be cached but was requested %d times", mirrored)
+	}
+}
+
+func TestAssetsMirrorCache(t *testing.T) {
+	requests := 0
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, req *http.Request) {
+		requests++
+
+		switch req.URL.Path {
+		case "/css/slow.css":
+			close(started)
+			<-release
+
+			_, _ = fmt.Fprint(responseWriter, "body{}")
+		case "/css/broken.css":
+			responseWriter.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			http.NotFound(responseWriter, req)
+		}
+	}))
+	defer upstream.Close()
+
+	config := Config{ReservedPath: "/__themepark", Assets: Assets{Mirror: true, source: upstream.URL}}
+
+	assets, err := newAssetServer(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Failures are kept for a while rather than fetched for every request.
+	for attempt := 0; attempt < 2; attempt++ {
+		if _, err := assets.load("/css/broken.css"); err == nil || errors.Is(err, errAssetNotFound) {
+			t.Errorf("got error: %v\n wanted: unexpected status", err)
+		}
+
+		if _, err := assets.load("/css/missing.css"); !errors.Is(err, errAssetNotFound) {
+			t.Errorf("got error: %v\n wanted: %v", err, errAssetNotFound)
+		}
+	}
+
+	if requests != 2 {
+		t.Errorf("failures should be cached but %d requests were sent", requests)
+	}
+
+	// Requests for an asset that is being fetched wait for that fetch.
+	loaded := make(chan []byte)
+
+	go func() {
+		body, _ := assets.load("/css/slow.css")
+		loaded <- body
+	}()
+
+	<-started
+
+	entry, fetching := assets.lookup("/css/slow.css")
+	if fetching {
+		t.Error("an asset being fetched should not be fetched again")
+	}
+
+	close(release)
+
+	if body := <-loaded; string(body) != "body{}" {
+		t.Errorf("got body: %s\n wanted: body{}", body)
+	}
+
+	<-entry.loaded
+
+	if string(entry.body) != "body{}" || requests != 3 {
+		t.Errorf("got body: %s after %d requests\n wanted: body{} after 3 requests", entry.body, requests)
+	}
+}
+
+func TestAssetsMirrorLimit(t *testing.T) {
+	config := Config{ReservedPath: "/__themepark", Assets: Assets{Mirror: true}}
+
+	assets, err := newAssetServer(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fill := func(expired int) {
+		assets.entries = make(map[string]*assetEntry)
+
+		for index := 0; index < maxAssetEntries; index++ {
+			entry := &assetEntry{done: true, expires: time.Now().Add(time.Hour)}
+			if index < expired {
+				entry.expires = time.Now().Add(-time.Second)
+			}
+
+			assets.entries[fmt.Sprintf("/css/%d.css", index)] = entry
+		}
+	}
+
+	// Expired entries make room for new ones first.
+	fill(1)
+	assets.lookup("/css/new.css")
+
+	if len(assets.entries) != maxAssetEntries {
+		t.Errorf("got %d entries\n wanted: %d", len(assets.entries), maxAssetEntries)
+	}
+
+	// The cache starts over when no entry has expired.
+	fill(0)
+	assets.lookup("/css/new.css")
+
+	if len(assets.entries) != 1 {
+		t.Errorf("got %d entries\n wanted: 1", len(assets.entries))
+	}
+}
+
+func TestAssetsInline(t *testing.T) {
+	directory := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(directory, "css", "base", "sonarr"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	err := os.WriteFile(filepath.Join(directory, "css", "base", "sonarr", "nord.css"), []byte("body{color:red}"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := Config{App: "sonarr", Theme: "nord", Inline: true, Assets: Assets{Directory: directory}}
+
+	next := func(responseWriter http.ResponseWriter, _ *http.Request) {
+		responseWriter.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprint(responseWriter, "<head></head><body></body>")
+	}
+
+	themePark, err := New(context.Background(), http.HandlerFunc(next), &config, "themepark")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html")
+
+	themePark.ServeHTTP(recorder, req)
+
+	expected := "<head></head><body><style data-themepark=\"base\">body{color:red}</style></body>"
+	if recorder.Body.String() != expected {
+		t.Errorf("got body: %s\n wanted: %s", recorder.Body.String(), expected)
+	}
+}
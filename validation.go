@@ -0,0 +1,188 @@
+package traefik_themepark
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxSuggestions limits how many alternatives are listed for an unknown value.
+const maxSuggestions = 3
+
+// validate ensures the configured app, theme and addons are known to the theme.park catalog.
+func (config *Config) validate() error {
+	if config.SkipValidation {
+		return nil
+	}
+
+	if config.App != autoApp && !containsString(catalogApps, config.App) {
+		return fmt.Errorf("unknown app %q%s", config.App, suggestionHint(config.App, catalogApps))
+	}
+
+	themes := append([]string{config.Theme, config.LightTheme, config.DarkTheme}, config.Switcher.Themes...)
+	for _, theme := range themes {
+		if err := config.validateTheme(theme); err != nil {
+			return err
+		}
+	}
+
+	addons := append(append([]string{}, config.Addons...), config.Switcher.Addons...)
+	for _, addon := range addons {
+		if err := config.validateAddon(addon); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (config *Config) validateTheme(theme string) error {
+	if theme == "" {
+		return nil
+	}
+
+	if theme != config.App+"-base" && !containsString(catalogThemes, theme) {
+		return fmt.Errorf("unknown theme %q%s", theme, suggestionHint(theme, catalogThemes))
+	}
+
+	return nil
+}
+
+func (config *Config) validateAddon(addon string) error {
+	availableAddons := catalogAddons[config.App]
+	if config.App == autoApp {
+		availableAddons = getAddonSuffixes()
+	}
+
+	if len(availableAddons) == 0 {
+		return fmt.Errorf("app %q does not support addons but %q was requested", config.App, addon)
+	}
+
+	addonName := config.getAddonName(addon)
+	if !containsString(availableAddons, addonName) {
+		return fmt.Errorf("unknown addon %q for app %q%s", addon, config.App, suggestionHint(addonName, availableAddons))
+	}
+
+	return nil
+}
+
+// getAddonName get the full addon name, including the app prefix, for the provided addon.
+func (config *Config) getAddonName(addon string) string {
+	// The prefix is added once the app is detected.
+	if config.App == autoApp || strings.HasPrefix(addon, config.App) {
+		return addon
+	}
+
+	return config.App + "-" + addon
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+
+	return false
+}
+
+// suggestionHint build a "did you mean" hint listing the candidates closest to value.
+func suggestionHint(value string, candidates []string) string {
+	suggestions := getSuggestions(value, candidates)
+
+	switch len(suggestions) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf(" (did you mean %q?)", suggestions[0])
+	default:
+		quoted := make([]string, len(suggestions))
+		for index, suggestion := range suggestions {
+			quoted[index] = fmt.Sprintf("%q", suggestion)
+		}
+
+		return fmt.Sprintf(" (did you mean one of %s?)", strings.Join(quoted, ", "))
+	}
+}
+
+type suggestion struct {
+	value    string
+	distance int
+}
+
+func getSuggestions(value string, candidates []string) []string {
+	value = strings.ToLower(value)
+	// Allow roughly one typo for every three characters with a minimum of two.
+	threshold := len(value) / 3
+	if minThreshold := 2; threshold < minThreshold {
+		threshold = minThreshold
+	}
+
+	matches := make([]suggestion, 0, maxSuggestions)
+
+	for _, candidate := range candidates {
+		distance := levenshtein(value, candidate)
+		if distance == 0 {
+			// Only differs by case so there is no reason to list anything else.
+			return []string{candidate}
+		}
+
+		if distance <= threshold || (value != "" && strings.Contains(candidate, value)) {
+			matches = append(matches, suggestion{value: candidate, distance: distance})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].distance < matches[j].distance
+	})
+
+	if len(matches) > maxSuggestions {
+		matches = matches[:maxSuggestions]
+	}
+
+	result := make([]string, len(matches))
+	for index, match := range matches {
+		result[index] = match.value
+	}
+
+	return result
+}
+
+// levenshtein compute the edit distance between two strings.
+func levenshtein(first, second string) int {
+	previous := make([]int, len(second)+1)
+	current := make([]int, len(second)+1)
+
+	for index := range previous {
+		previous[index] = index
+	}
+
+	for i := 1; i <= len(first); i++ {
+		current[0] = i
+
+		for j := 1; j <= len(second); j++ {
+			cost := 1
+			if first[i-1] == second[j-1] {
+				cost = 0
+			}
+
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(second)]
+}
+
+func minInt(first int, others ...int) int {
+	result := first
+
+	for _, value := range others {
+		if value < result {
+			result = value
+		}
+	}
+
+	return result
+}